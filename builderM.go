@@ -2,11 +2,9 @@ package kormongo
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"strings"
+	"time"
 
-	"github.com/kamalshkeir/klog"
 	"github.com/kamalshkeir/kmongodriver"
 )
 
@@ -25,6 +23,11 @@ type BuilderM struct {
 	args       []any
 	order      []string
 	ctx        context.Context
+	slow       time.Duration
+	cacheTTL   time.Duration
+	noCache    bool
+	refresh    bool
+	notFound   time.Duration
 }
 
 func Table(tableName string) *BuilderM {
@@ -46,7 +49,7 @@ func (b *BuilderM) Database(dbName string) *BuilderM {
 
 func (b *BuilderM) Select(columns ...string) *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse .Table before .Select\n")
+		logger.Error("use Table before Select")
 		return nil
 	}
 	s := []string{}
@@ -58,7 +61,7 @@ func (b *BuilderM) Select(columns ...string) *BuilderM {
 
 func (b *BuilderM) Where(fieldsCommaSeparated string, args ...any) *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse .Table before .Where\n")
+		logger.Error("use Table before Where")
 		return nil
 	}
 	b.whereQuery = fieldsCommaSeparated
@@ -69,7 +72,7 @@ func (b *BuilderM) Where(fieldsCommaSeparated string, args ...any) *BuilderM {
 
 func (b *BuilderM) Limit(limit int) *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse db.Table before Limit\n")
+		logger.Error("use Table before Limit")
 		return nil
 	}
 	b.limit = limit
@@ -79,7 +82,7 @@ func (b *BuilderM) Limit(limit int) *BuilderM {
 
 func (b *BuilderM) Page(pageNumber int) *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse db.Table before Page\n")
+		logger.Error("use Table before Page")
 		return nil
 	}
 	b.page = pageNumber
@@ -97,7 +100,7 @@ func (b *BuilderM) OrderBy(fields ...string) *BuilderM {
 		return b
 	}
 	if b.tableName == "" {
-		klog.Printf("rdUse db.Table before OrderBy\n")
+		logger.Error("use Table before OrderBy")
 		return nil
 	}
 	b.orderBys = "ORDER BY "
@@ -118,150 +121,194 @@ func (b *BuilderM) OrderBy(fields ...string) *BuilderM {
 
 func (b *BuilderM) Context(ctx context.Context) *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse db.Table before Context\n")
+		logger.Error("use Table before Context")
 		return nil
 	}
 	b.ctx = ctx
 	return b
 }
 
+// SlowThreshold record the operation in SlowQueries if it take longer than threshold, override SlowQueryThreshold
+func (b *BuilderM) SlowThreshold(threshold time.Duration) *BuilderM {
+	b.slow = threshold
+	return b
+}
+
+// Cache keep the result of All and One in the cache for ttl instead of until the next FlushCacheEvery sweep, writes on the table still invalidate it
+func (b *BuilderM) Cache(ttl time.Duration) *BuilderM {
+	b.cacheTTL = ttl
+	return b
+}
+
+// NoCache neither read nor store the result of All and One in the cache
+func (b *BuilderM) NoCache() *BuilderM {
+	b.noCache = true
+	return b
+}
+
+// Refresh skip the cache lookup of All and One and store the fresh result
+func (b *BuilderM) Refresh() *BuilderM {
+	b.refresh = true
+	return b
+}
+
+// CacheNotFound cache for ttl the result of One when no document match, so the lookups of missing values don't reach the database
+// it override NotFoundCacheTTL, a negative ttl disable it for the query
+func (b *BuilderM) CacheNotFound(ttl time.Duration) *BuilderM {
+	b.notFound = ttl
+	return b
+}
+
+// Debug log the operation using the logger set with SetLogger: filter, projection, sort, duration, documents count and cache hit/miss
 func (b *BuilderM) Debug() *BuilderM {
 	if b.tableName == "" {
-		klog.Printf("rdUse db.Table before Debug\n")
+		logger.Error("use Table before Debug")
 		return nil
 	}
 	b.debug = true
 	return b
 }
 
-func (b *BuilderM) All() ([]map[string]any, error) {
+func (b *BuilderM) All() (data []map[string]any, err error) {
 	if b.tableName == "" {
-		return nil, errors.New("unable to find table, try db.Table before")
-	}
-	c := dbCache{
-		database:   b.database,
-		table:      b.tableName,
-		selected:   b.selected,
-		statement:  b.statement,
-		orderBys:   b.orderBys,
-		whereQuery: b.whereQuery,
-		query:      b.query,
-		offset:     b.offset,
-		limit:      b.limit,
-		page:       b.page,
-		args:       fmt.Sprintf("%v", b.args...),
-	}
-	if useCache {
-		if v, ok := cachesAllM.Get(c); ok {
-			return v, nil
-		}
+		return nil, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-
-	wf := map[string]any{}
-	if b.whereQuery != "" {
-		if strings.Contains(b.whereQuery, ",") {
-			sp := strings.Split(b.whereQuery, ",")
-			if len(b.args) == len(sp) {
-				for i, s := range sp {
-					wf[strings.TrimSpace(s)] = b.args[i]
-				}
-			}
-		} else {
-			if len(b.args) == 1 {
-				wf[strings.TrimSpace(b.whereQuery)] = b.args[0]
-			}
-		}
-	}
-	if len(wf) == 0 {
-		wf = nil
+	ql := newQueryLog(b.debug, b.slow, "find", b.database, b.tableName)
+	ql.projection, ql.sort = b.selected, b.orderBys
+	defer func() { ql.done(len(data), err) }()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return nil, err
 	}
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	data, err := kmongodriver.Query[map[string]any](b.ctx, b.tableName, b.selected, wf, int64(b.limit), int64(b.page), b.orderBys, b.database)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "find", Database: b.database, Table: b.tableName, Filter: b.whereFields()}, func(op *OpInfo) error {
+		wf := op.Filter
+		if len(wf) == 0 {
+			wf = nil
+		}
+		ql.filter = wf
+		fetch := func(ctx context.Context) ([]map[string]any, error) {
+			res, err := findAll[map[string]any](ctx, db.MongoConn, b.tableName, wf, b.selected, b.orderBys, int64(b.limit), int64(b.page))
+			if err != nil {
+				return nil, queryError("find", b.database, b.tableName, wf, err)
+			}
+			return res, nil
+		}
+		if !useCache || b.noCache {
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		key, err := queryKey(b.database, b.tableName, "", wf, b.selected, b.orderBys, b.limit, b.page)
+		if err != nil {
+			// not encodable, the query itself fail the same way
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		started, gen := time.Now(), cacheGeneration(b.database, b.tableName)
+		res, hit, err := cachedQuery(op.Ctx, "all_m", cachesAllM, key, gen, b.refresh, fetch, func(res []map[string]any) {
+			fillCache(b.database, b.tableName, gen, func() {
+				storeSet(cachesAllM, key, res, b.cacheTTL, started, cacheTags(b.database, b.tableName, wf, b.orderBys, b.limit > 0 || b.page > 1, res)...)
+			})
+		})
+		ql.cacheLookup("all_m", hit)
+		op.Cache = ql.cache
+		data = res
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if useCache {
-		cachesAllM.Set(c, data)
-	}
 	return data, nil
 }
 
-func (b *BuilderM) One() (map[string]any, error) {
+func (b *BuilderM) One() (data map[string]any, err error) {
 	if b.tableName == "" {
-		return nil, errors.New("unable to find table, try db.Table before")
-	}
-	c := dbCache{
-		database:   b.database,
-		table:      b.tableName,
-		selected:   b.selected,
-		statement:  b.statement,
-		orderBys:   b.orderBys,
-		whereQuery: b.whereQuery,
-		query:      b.query,
-		offset:     b.offset,
-		limit:      b.limit,
-		page:       b.page,
-		args:       fmt.Sprintf("%v", b.args...),
-	}
-	if useCache {
-		if v, ok := cachesOneM.Get(c); ok {
-			return v, nil
-		}
+		return nil, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	wf := map[string]any{}
-	if b.whereQuery != "" {
-		if strings.Contains(b.whereQuery, ",") {
-			sp := strings.Split(b.whereQuery, ",")
-			if len(b.args) == len(sp) {
-				for i, s := range sp {
-					wf[strings.TrimSpace(s)] = b.args[i]
-				}
-			}
-		} else {
-			if len(b.args) == 1 {
-				wf[strings.TrimSpace(b.whereQuery)] = b.args[0]
-			}
+	ql := newQueryLog(b.debug, b.slow, "findOne", b.database, b.tableName)
+	ql.projection, ql.sort = b.selected, b.orderBys
+	defer func() {
+		n := 0
+		if data != nil {
+			n = 1
 		}
-	}
-	if len(wf) == 0 {
-		wf = nil
+		ql.done(n, err)
+	}()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return nil, err
 	}
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	data, err := kmongodriver.QueryOne[map[string]any](b.ctx, b.tableName, b.selected, wf, int64(b.limit), int64(b.page), strings.ReplaceAll(b.orderBys, "ORDER BY", ""), b.database)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "findOne", Database: b.database, Table: b.tableName, Filter: b.whereFields()}, func(op *OpInfo) error {
+		wf := op.Filter
+		if len(wf) == 0 {
+			wf = nil
+		}
+		ql.filter = wf
+		fetch := func(ctx context.Context) (map[string]any, error) {
+			res, err := findOne[map[string]any](ctx, db.MongoConn, b.tableName, wf, b.selected, b.orderBys, int64(b.limit), int64(b.page))
+			if err != nil {
+				return nil, queryError("findOne", b.database, b.tableName, wf, err)
+			}
+			return res, nil
+		}
+		if !useCache || b.noCache {
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		key, err := queryKey(b.database, b.tableName, "", wf, b.selected, b.orderBys, b.limit, b.page)
+		if err != nil {
+			// not encodable, the query itself fail the same way
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		started, gen := time.Now(), cacheGeneration(b.database, b.tableName)
+		negative := notFoundTTL(b.notFound)
+		res, hit, err := cachedQuery(op.Ctx, "one_m", cachesOneM, key, gen, b.refresh, func(ctx context.Context) (oneResult[map[string]any], error) {
+			return fetchOne(ctx, fetch, negative > 0)
+		}, func(res oneResult[map[string]any]) {
+			ttl := b.cacheTTL
+			if res.NotFound {
+				ttl = negative
+			}
+			fillCache(b.database, b.tableName, gen, func() {
+				storeSet(cachesOneM, key, res, ttl, started, cacheTags(b.database, b.tableName, wf, b.orderBys, b.page > 1, res.Doc)...)
+			})
+		})
+		ql.cacheLookup("one_m", hit)
+		op.Cache = ql.cache
+		if err == nil && res.NotFound {
+			err = queryError("findOne", b.database, b.tableName, wf, ErrNotFound)
+		}
+		data = res.Doc
+		return err
+	})
 	if err != nil {
 		return nil, err
 	}
-	if useCache {
-		cachesOneM.Set(c, data)
-	}
 	return data, nil
 }
 
 // Insert usage: Insert("email, is_admin","example@mail.com",true)
-func (b *BuilderM) Insert(fieldsCommaSeparated string, fields_values ...any) (int, error) {
+func (b *BuilderM) Insert(fieldsCommaSeparated string, fields_values ...any) (n int, err error) {
 	if b.tableName == "" {
-		return 0, errors.New("unable to find table, try db.Table before")
+		return 0, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "create",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "insert", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 
 	db, err := GetMemoryDatabase(b.database)
 	if err != nil {
@@ -277,28 +324,30 @@ func (b *BuilderM) Insert(fieldsCommaSeparated string, fields_values ...any) (in
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	err = kmongodriver.CreateRow(b.ctx, b.tableName, mmm, db.Name)
-	if klog.CheckError(err) {
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "insert", Database: db.Name, Table: b.tableName}, func(op *OpInfo) error {
+		tags := insertTags(db.Name, b.tableName, mmm)
+		err := queryError("insert", db.Name, b.tableName, nil, insertOne(op.Ctx, db.MongoConn, b.tableName, mmm))
+		if writeApplied(err) {
+			invalidate("create", db.Name, b.tableName, tags)
+		}
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
 	return 1, nil
 }
 
-// Set usage: Set("email, is_admin","example@mail.com",true)
-func (b *BuilderM) Set(fieldsCommaSeparated string, args ...any) (int, error) {
+// Set usage: Set("email, is_admin","example@mail.com",true) or Set("email = ?, is_admin = ?","example@mail.com",true)
+func (b *BuilderM) Set(fieldsCommaSeparated string, args ...any) (n int, err error) {
 	if b.tableName == "" {
-		return 0, errors.New("unable to find model, try db.Table before")
+		return 0, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "update",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "update", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
 	if err != nil {
 		return 0, err
@@ -322,33 +371,36 @@ func (b *BuilderM) Set(fieldsCommaSeparated string, args ...any) (int, error) {
 			}
 		}
 	}
-	newRow := map[string]any{}
-	spp := strings.Split(fieldsCommaSeparated, ",")
-	for _, s := range spp {
-		seq := strings.Split(s, "=")
-		newRow[seq[0]] = seq[1]
+	newRow, err := setFields(fieldsCommaSeparated, args...)
+	if err != nil {
+		return 0, err
 	}
-	err = kmongodriver.UpdateRow(b.ctx, b.tableName, wf, newRow,db.Name)
-	if klog.CheckError(err) {
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "update", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		ql.filter = op.Filter
+		fields := make([]string, 0, len(newRow))
+		for k := range newRow {
+			fields = append(fields, k)
+		}
+		id, err := updateOne(op.Ctx, db.MongoConn, b.tableName, op.Filter, map[string]any{"$set": newRow})
+		err = queryError("update", db.Name, b.tableName, op.Filter, err)
+		invalidateWrite("update", db.Name, b.tableName, id, err, fields, false)
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
 	return 1, nil
 }
 
-func (b *BuilderM) Delete() (int, error) {
+func (b *BuilderM) Delete() (n int, err error) {
 	if b.tableName == "" {
-		return 0, errors.New("unable to find model, try korm.AutoMigrate before")
+		return 0, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "delete",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "delete", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
 	if err != nil {
 		return 0, err
@@ -372,27 +424,28 @@ func (b *BuilderM) Delete() (int, error) {
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	err = kmongodriver.DeleteRow(b.ctx, b.tableName, wf, db.Name)
-	if klog.CheckError(err) {
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "delete", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		ql.filter = op.Filter
+		id, err := deleteOne(op.Ctx, db.MongoConn, b.tableName, op.Filter)
+		err = queryError("delete", db.Name, b.tableName, op.Filter, err)
+		invalidateWrite("delete", db.Name, b.tableName, id, err, nil, true)
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
 	return 1, nil
 }
 
-func (b *BuilderM) Drop() (int, error) {
+func (b *BuilderM) Drop() (n int, err error) {
 	if b.tableName == "" {
-		return 0, errors.New("unable to find model, try korm.LinkModel before Update")
+		return 0, ErrTableNotLinked
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "drop",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "drop", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
 	if err != nil {
 		return 0, err
@@ -401,6 +454,35 @@ func (b *BuilderM) Drop() (int, error) {
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	kmongodriver.DropTable(b.ctx, b.tableName, db.Name)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "drop", Database: db.Name, Table: b.tableName}, func(op *OpInfo) error {
+		err := queryError("drop", db.Name, b.tableName, nil, db.MongoConn.Collection(b.tableName).Drop(op.Ctx))
+		if writeApplied(err) {
+			invalidate("drop", db.Name, b.tableName, nil)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
 	return 1, nil
 }
+
+// whereFields return the filter built from Where
+func (b *BuilderM) whereFields() map[string]any {
+	wf := map[string]any{}
+	if b.whereQuery != "" {
+		if strings.Contains(b.whereQuery, ",") {
+			sp := strings.Split(b.whereQuery, ",")
+			if len(b.args) == len(sp) {
+				for i, s := range sp {
+					wf[strings.TrimSpace(s)] = b.args[i]
+				}
+			}
+		} else {
+			if len(b.args) == 1 {
+				wf[strings.TrimSpace(b.whereQuery)] = b.args[0]
+			}
+		}
+	}
+	return wf
+}
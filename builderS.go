@@ -2,17 +2,15 @@ package kormongo
 
 import (
 	"context"
-	"errors"
-	"fmt"
 	"strings"
+	"time"
 
-	"github.com/kamalshkeir/klog"
-	"github.com/kamalshkeir/kmap"
 	"github.com/kamalshkeir/kmongodriver"
+	"go.mongodb.org/mongo-driver/mongo"
 )
 
-var cachesOneS = kmap.New[dbCache, any](false)
-var cachesAllS = kmap.New[dbCache, any](false)
+var cachesOneS CacheStore = newLRU("one_s")
+var cachesAllS CacheStore = newLRU("all_s")
 
 type Builder[T comparable] struct {
 	debug      bool
@@ -29,6 +27,13 @@ type Builder[T comparable] struct {
 	args       []any
 	order      []string
 	ctx        context.Context
+	slow       time.Duration
+	cacheTTL   time.Duration
+	noCache    bool
+	refresh    bool
+	notFound   time.Duration
+	trashed    int
+	force      bool
 }
 
 func Model[T comparable](tableName ...string) *Builder[T] {
@@ -38,7 +43,7 @@ func Model[T comparable](tableName ...string) *Builder[T] {
 			mModelTablename[*new(T)] = tableName[0]
 			tName = tableName[0]
 		} else {
-			klog.Printf("rdunable to find tableName from model, restart the app if you just migrated\n")
+			logger.Error("unable to find tableName from model, restart the app if you just migrated")
 			return nil
 		}
 	}
@@ -54,7 +59,7 @@ func BuilderS[T comparable](tableName ...string) *Builder[T] {
 			mModelTablename[*new(T)] = tableName[0]
 			tName = tableName[0]
 		} else {
-			klog.Printf("rdunable to find tableName from model, restart the app if you just migrated\n")
+			logger.Error("unable to find tableName from model, restart the app if you just migrated")
 			return nil
 		}
 	}
@@ -69,7 +74,8 @@ func (b *Builder[T]) Database(dbName string) *Builder[T] {
 		b.database = databases[0].Name
 	}
 	db, err := GetMemoryDatabase(b.database)
-	if klog.CheckError(err) {
+	if err != nil {
+		logger.Error(err.Error())
 		b.database = databases[0].Name
 	} else {
 		b.database = db.Name
@@ -77,61 +83,70 @@ func (b *Builder[T]) Database(dbName string) *Builder[T] {
 	return b
 }
 
-func (b *Builder[T]) Insert(model *T) (int, error) {
+func (b *Builder[T]) Insert(model *T) (n int, err error) {
 	if b.tableName == "" {
 		tName := getTableName[T]()
 		if tName == "" {
-			return 0, errors.New("unable to find tableName from model, restart the app if you just migrated")
+			return 0, ErrTableNotLinked
 		}
 		b.tableName = tName
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "create",
-			"table":    b.tableName,
-			"database": b.database,
-		})
+	ql := newQueryLog(b.debug, b.slow, "insert", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
+	if b.ctx == nil {
+		b.ctx = context.Background()
 	}
+	hctx := withHookContext(b.ctx, &HookContext{Database: b.database, Table: b.tableName})
+	if h, ok := any(model).(BeforeInserter); ok {
+		if err := h.BeforeInsert(hctx); err != nil {
+			return 0, err
+		}
+	}
+	setTimestamps(model, true)
 	db, err := GetMemoryDatabase(b.database)
-	if klog.CheckError(err) {
+	if err != nil {
 		return 0, err
 	}
 
-	if b.ctx == nil {
-		b.ctx = context.Background()
-	}
-	err = kmongodriver.CreateRow(b.ctx, b.tableName, model, db.Name)
-	if klog.CheckError(err) {
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "insert", Database: db.Name, Table: b.tableName}, func(op *OpInfo) error {
+		tags := insertTags(db.Name, b.tableName, model)
+		err := queryError("insert", db.Name, b.tableName, nil, insertOne(op.Ctx, db.MongoConn, b.tableName, model))
+		if writeApplied(err) {
+			invalidate("create", db.Name, b.tableName, tags)
+		}
+		return err
+	})
+	if err != nil {
 		return 0, err
 	}
+	if h, ok := any(model).(AfterInserter); ok {
+		if err := h.AfterInsert(hctx); err != nil {
+			return 1, err
+		}
+	}
 	return 1, nil
 }
 
-// Set usage: Set("email, is_admin","example@mail.com",true)
-func (b *Builder[T]) Set(fieldsCommaSeparated string, args ...any) (int, error) {
+// Set usage: Set("email, is_admin","example@mail.com",true) or Set("email = ?, is_admin = ?","example@mail.com",true)
+// for models with a korm:"version" field Where must include the version, ErrVersionNotChecked is returned otherwise, the version is incremented and ErrStaleObject returned if no document matched
+func (b *Builder[T]) Set(fieldsCommaSeparated string, args ...any) (n int, err error) {
 	if b.tableName == "" {
 		tName := getTableName[T]()
 		if tName == "" {
-			klog.Printf("rdunable to find tableName from model\n")
-			return 0, errors.New("unable to find tableName from model")
+			return 0, ErrTableNotLinked
 		}
 		b.tableName = tName
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "update",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "update", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
-	if klog.CheckError(err) {
+	if err != nil {
 		return 0, err
 	}
 
@@ -153,40 +168,74 @@ func (b *Builder[T]) Set(fieldsCommaSeparated string, args ...any) (int, error)
 			}
 		}
 	}
-	newRow := map[string]any{}
-	spp := strings.Split(fieldsCommaSeparated, ",")
-	for _, s := range spp {
-		seq := strings.Split(s, "=")
-		newRow[seq[0]] = seq[1]
+	if col := versionColumn[T](); col != "" {
+		if _, ok := wf[col]; !ok {
+			return 0, ErrVersionNotChecked
+		}
 	}
-	err = kmongodriver.UpdateRow(b.ctx, b.tableName, wf, newRow,db.Name)
-	if klog.CheckError(err) {
+	newRow, err := setFields(fieldsCommaSeparated, args...)
+	if err != nil {
 		return 0, err
 	}
+	ql.filter = wf
+	hctx := withHookContext(b.ctx, &HookContext{Database: db.Name, Table: b.tableName, Filter: wf, Update: newRow})
+	if h, ok := any(new(T)).(BeforeUpdater); ok {
+		if err := h.BeforeUpdate(hctx); err != nil {
+			return 0, err
+		}
+	}
+	setUpdatedTimestamp[T](newRow)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "update", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		wf := op.Filter
+		ql.filter = wf
+		fields := make([]string, 0, len(newRow)+1)
+		for k := range newRow {
+			fields = append(fields, k)
+		}
+		col := versionColumn[T]()
+		if col != "" {
+			fields = append(fields, col)
+		}
+		update := map[string]any{"$set": newRow}
+		if col != "" {
+			delete(newRow, col)
+			update["$inc"] = map[string]any{col: 1}
+		}
+		id, err := updateOne(op.Ctx, db.MongoConn, b.tableName, wf, update)
+		if err == nil && id == nil && col != "" {
+			err = ErrStaleObject
+		}
+		err = queryError("update", db.Name, b.tableName, wf, err)
+		invalidateWrite("update", db.Name, b.tableName, id, err, fields, false)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	if h, ok := any(new(T)).(AfterUpdater); ok {
+		if err := h.AfterUpdate(hctx); err != nil {
+			return 1, err
+		}
+	}
 	return 1, nil
 }
 
-func (b *Builder[T]) Delete() (int, error) {
+// Delete delete the first document matching Where, models with a korm:"softdelete" field are only marked as deleted, use ForceDelete to remove them
+func (b *Builder[T]) Delete() (n int, err error) {
 	if b.tableName == "" {
 		tName := getTableName[T]()
 		if tName == "" {
-			klog.Printf("unable to find tableName from model\n")
-			return 0, errors.New("unable to find tableName from model")
+			return 0, ErrTableNotLinked
 		}
 		b.tableName = tName
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "delete",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "delete", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
-	if klog.CheckError(err) {
+	if err != nil {
 		return 0, err
 	}
 	wf := map[string]any{}
@@ -207,39 +256,76 @@ func (b *Builder[T]) Delete() (int, error) {
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	err = kmongodriver.DeleteRow(b.ctx, b.tableName, wf, db.Name)
-	if klog.CheckError(err) {
+	ql.filter = wf
+	hctx := withHookContext(b.ctx, &HookContext{Database: db.Name, Table: b.tableName, Filter: wf})
+	if h, ok := any(new(T)).(BeforeDeleter); ok {
+		if err := h.BeforeDelete(hctx); err != nil {
+			return 0, err
+		}
+	}
+	if col := softDeleteColumn[T](); col != "" && !b.force {
+		b.trashed = withoutTrashed
+		err = runOp(&OpInfo{Ctx: b.ctx, Kind: "delete", Database: db.Name, Table: b.tableName, Filter: b.scope(wf)}, func(op *OpInfo) error {
+			ql.filter = op.Filter
+			// the document leave the results containing it and join the onlyTrashed ones
+			id, err := updateOne(op.Ctx, db.MongoConn, b.tableName, op.Filter, map[string]any{"$set": map[string]any{col: now()}})
+			if err == nil && id == nil {
+				err = mongo.ErrNoDocuments
+			}
+			err = queryError("delete", db.Name, b.tableName, op.Filter, err)
+			invalidateWrite("delete", db.Name, b.tableName, id, err, nil, true, flagTag(db.Name, b.tableName, "trashed"))
+			return err
+		})
+	} else {
+		err = runOp(&OpInfo{Ctx: b.ctx, Kind: "delete", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+			ql.filter = op.Filter
+			id, err := deleteOne(op.Ctx, db.MongoConn, b.tableName, op.Filter)
+			err = queryError("delete", db.Name, b.tableName, op.Filter, err)
+			invalidateWrite("delete", db.Name, b.tableName, id, err, nil, true)
+			return err
+		})
+	}
+	if err != nil {
 		return 0, err
 	}
+	if h, ok := any(new(T)).(AfterDeleter); ok {
+		if err := h.AfterDelete(hctx); err != nil {
+			return 1, err
+		}
+	}
 	return 1, nil
 }
 
-func (b *Builder[T]) Drop() (int, error) {
+func (b *Builder[T]) Drop() (n int, err error) {
 	if b.tableName == "" {
 		tName := getTableName[T]()
 		if tName == "" {
-			return 0, errors.New("unable to find tableName from model")
+			return 0, ErrTableNotLinked
 		}
 		b.tableName = tName
 	}
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
-	if useCache {
-		go cachebus.Publish(CACHE_TOPIC, map[string]any{
-			"type":     "drop",
-			"table":    b.tableName,
-			"database": b.database,
-		})
-	}
+	ql := newQueryLog(b.debug, b.slow, "drop", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
 	db, err := GetMemoryDatabase(b.database)
-	if klog.CheckError(err) {
+	if err != nil {
 		return 0, err
 	}
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	kmongodriver.DropTable(b.ctx, b.tableName, db.Name)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "drop", Database: db.Name, Table: b.tableName}, func(op *OpInfo) error {
+		err := queryError("drop", db.Name, b.tableName, nil, db.MongoConn.Collection(b.tableName).Drop(op.Ctx))
+		if writeApplied(err) {
+			invalidate("drop", db.Name, b.tableName, nil)
+		}
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
 	return 1, nil
 }
 
@@ -301,93 +387,231 @@ func (b *Builder[T]) OrderBy(fields ...string) *Builder[T] {
 	return b
 }
 
+// SlowThreshold record the operation in SlowQueries if it take longer than threshold, override SlowQueryThreshold
+func (b *Builder[T]) SlowThreshold(threshold time.Duration) *Builder[T] {
+	b.slow = threshold
+	return b
+}
+
+// Cache keep the result of All and One in the cache for ttl instead of until the next FlushCacheEvery sweep, writes on the table still invalidate it
+func (b *Builder[T]) Cache(ttl time.Duration) *Builder[T] {
+	b.cacheTTL = ttl
+	return b
+}
+
+// NoCache neither read nor store the result of All and One in the cache
+func (b *Builder[T]) NoCache() *Builder[T] {
+	b.noCache = true
+	return b
+}
+
+// Refresh skip the cache lookup of All and One and store the fresh result
+func (b *Builder[T]) Refresh() *Builder[T] {
+	b.refresh = true
+	return b
+}
+
+// CacheNotFound cache for ttl the result of One when no document match, so the lookups of missing values don't reach the database
+// it override NotFoundCacheTTL, a negative ttl disable it for the query
+func (b *Builder[T]) CacheNotFound(ttl time.Duration) *Builder[T] {
+	b.notFound = ttl
+	return b
+}
+
+// Debug log the operation using the logger set with SetLogger: filter, projection, sort, duration, documents count and cache hit/miss
 func (b *Builder[T]) Debug() *Builder[T] {
 	b.debug = true
 	return b
 }
 
-func (b *Builder[T]) All() ([]T, error) {
+func (b *Builder[T]) All() (data []T, err error) {
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
 	if b.tableName == "" {
-		return nil, errors.New("error: this model is not linked, execute korm.AutoMigrate before")
-	}
-	c := dbCache{
-		database:   b.database,
-		table:      b.tableName,
-		selected:   b.selected,
-		statement:  b.statement,
-		orderBys:   b.orderBys,
-		whereQuery: b.whereQuery,
-		query:      b.query,
-		offset:     b.offset,
-		limit:      b.limit,
-		page:       b.page,
-		args:       fmt.Sprintf("%v", b.args...),
-	}
-	if useCache {
-		if v, ok := cachesAllS.Get(c); ok {
-			return v.([]T), nil
-		}
+		return nil, ErrTableNotLinked
 	}
-	wf := map[string]any{}
-	if b.whereQuery != "" {
-		if strings.Contains(b.whereQuery, ",") {
-			sp := strings.Split(b.whereQuery, ",")
-			if len(b.args) == len(sp) {
-				for i, s := range sp {
-					wf[strings.TrimSpace(s)] = b.args[i]
-				}
+	ql := newQueryLog(b.debug, b.slow, "find", b.database, b.tableName)
+	ql.projection, ql.sort = b.selected, b.orderBys
+	defer func() { ql.done(len(data), err) }()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return nil, err
+	}
+	wf := b.scope(b.whereFields())
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "find", Database: b.database, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		wf := op.Filter
+		if len(wf) == 0 {
+			wf = nil
+		}
+		ql.filter = wf
+		fetch := func(ctx context.Context) ([]T, error) {
+			res, err := findAll[T](ctx, db.MongoConn, b.tableName, wf, b.selected, b.orderBys, int64(b.limit), int64(b.page))
+			if err != nil {
+				return nil, queryError("find", b.database, b.tableName, wf, err)
 			}
-		} else {
-			if len(b.args) == 1 {
-				wf[strings.TrimSpace(b.whereQuery)] = b.args[0]
+			if err := afterFind(withHookContext(ctx, &HookContext{Database: b.database, Table: b.tableName, Filter: wf}), res); err != nil {
+				return nil, err
 			}
+			return res, nil
 		}
+		if !useCache || b.noCache {
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		key, err := queryKey(b.database, b.tableName, modelKey[T](), wf, b.selected, b.orderBys, b.limit, b.page)
+		if err != nil {
+			// not encodable, the query itself fail the same way
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		started, gen := time.Now(), cacheGeneration(b.database, b.tableName)
+		res, hit, err := cachedQuery(op.Ctx, "all_s", cachesAllS, key, gen, b.refresh, fetch, func(res []T) {
+			fillCache(b.database, b.tableName, gen, func() {
+				storeSet(cachesAllS, key, res, b.cacheTTL, started, b.cacheTags(wf, b.limit > 0 || b.page > 1, res)...)
+			})
+		})
+		ql.cacheLookup("all_s", hit)
+		op.Cache = ql.cache
+		data = res
+		return err
+	})
+	if err != nil {
+		return nil, err
 	}
+	return data, nil
+}
 
-	if len(wf) == 0 {
-		wf = nil
+func (b *Builder[T]) One() (data T, err error) {
+	if b.database == "" {
+		b.database = databases[0].Name
 	}
+	if b.tableName == "" {
+		return *new(T), ErrTableNotLinked
+	}
+	ql := newQueryLog(b.debug, b.slow, "findOne", b.database, b.tableName)
+	ql.projection, ql.sort = b.selected, b.orderBys
+	defer func() {
+		n := 1
+		if err != nil {
+			n = 0
+		}
+		ql.done(n, err)
+	}()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return *new(T), err
+	}
+	wf := b.scope(b.whereFields())
 	if b.ctx == nil {
 		b.ctx = context.Background()
 	}
-	data, err := kmongodriver.Query[T](b.ctx, b.tableName, b.selected, wf, int64(b.limit), int64(b.page), b.orderBys, b.database)
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "findOne", Database: b.database, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		wf := op.Filter
+		if len(wf) == 0 {
+			wf = nil
+		}
+		ql.filter = wf
+		fetch := func(ctx context.Context) (T, error) {
+			res, err := findOne[T](ctx, db.MongoConn, b.tableName, wf, b.selected, b.orderBys, int64(b.limit), int64(b.page))
+			if err != nil {
+				return *new(T), queryError("findOne", b.database, b.tableName, wf, err)
+			}
+			if h, ok := any(&res).(AfterFinder); ok {
+				if err := h.AfterFind(withHookContext(ctx, &HookContext{Database: b.database, Table: b.tableName, Filter: wf})); err != nil {
+					return *new(T), err
+				}
+			}
+			return res, nil
+		}
+		if !useCache || b.noCache {
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		key, err := queryKey(b.database, b.tableName, modelKey[T](), wf, b.selected, b.orderBys, b.limit, b.page)
+		if err != nil {
+			// not encodable, the query itself fail the same way
+			data, err = fetch(op.Ctx)
+			return err
+		}
+		started, gen := time.Now(), cacheGeneration(b.database, b.tableName)
+		negative := notFoundTTL(b.notFound)
+		res, hit, err := cachedQuery(op.Ctx, "one_s", cachesOneS, key, gen, b.refresh, func(ctx context.Context) (oneResult[T], error) {
+			return fetchOne(ctx, fetch, negative > 0)
+		}, func(res oneResult[T]) {
+			ttl := b.cacheTTL
+			if res.NotFound {
+				ttl = negative
+			}
+			fillCache(b.database, b.tableName, gen, func() {
+				storeSet(cachesOneS, key, res, ttl, started, b.cacheTags(wf, b.page > 1, res.Doc)...)
+			})
+		})
+		ql.cacheLookup("one_s", hit)
+		op.Cache = ql.cache
+		if err == nil && res.NotFound {
+			err = queryError("findOne", b.database, b.tableName, wf, ErrNotFound)
+		}
+		data = res.Doc
+		return err
+	})
 	if err != nil {
-		return nil, err
-	}
-	if useCache {
-		cachesAllS.Set(c, data)
+		return *new(T), err
 	}
 	return data, nil
 }
 
-func (b *Builder[T]) One() (T, error) {
+// Count return the number of documents matching Where
+func (b *Builder[T]) Count() (n int64, err error) {
 	if b.database == "" {
 		b.database = databases[0].Name
 	}
 	if b.tableName == "" {
-		return *new(T), errors.New("error: this model is not linked, execute korm.AutoMigrate first")
-	}
-	c := dbCache{
-		database:   b.database,
-		table:      b.tableName,
-		selected:   b.selected,
-		statement:  b.statement,
-		orderBys:   b.orderBys,
-		whereQuery: b.whereQuery,
-		query:      b.query,
-		offset:     b.offset,
-		limit:      b.limit,
-		page:       b.page,
-		args:       fmt.Sprintf("%v", b.args...),
-	}
-	if useCache {
-		if v, ok := cachesOneS.Get(c); ok {
-			return v.(T), nil
+		return 0, ErrTableNotLinked
+	}
+	ql := newQueryLog(b.debug, b.slow, "count", b.database, b.tableName)
+	defer func() { ql.done(int(n), err) }()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return 0, err
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	wf := b.scope(b.whereFields())
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "count", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		wf := op.Filter
+		if wf == nil {
+			wf = map[string]any{}
 		}
+		ql.filter = wf
+		res, err := db.MongoConn.Collection(b.tableName).CountDocuments(op.Ctx, wf)
+		if err != nil {
+			return queryError("count", db.Name, b.tableName, wf, err)
+		}
+		n = res
+		return nil
+	})
+	if err != nil {
+		return 0, err
 	}
+	return n, nil
+}
+
+// cacheTags return the tags of a result cached for the filter wf
+func (b *Builder[T]) cacheTags(wf map[string]any, paged bool, result any) []string {
+	tags := cacheTags(b.database, b.tableName, wf, b.orderBys, paged, result)
+	if b.trashed == onlyTrashed {
+		tags = append(tags, flagTag(b.database, b.tableName, "trashed"))
+	}
+	return tags
+}
+
+// whereFields return the filter built from Where
+func (b *Builder[T]) whereFields() map[string]any {
 	wf := map[string]any{}
 	if b.whereQuery != "" {
 		if strings.Contains(b.whereQuery, ",") {
@@ -403,19 +627,5 @@ func (b *Builder[T]) One() (T, error) {
 			}
 		}
 	}
-	if len(wf) == 0 {
-		wf = nil
-	}
-	if b.ctx == nil {
-		b.ctx = context.Background()
-	}
-	data, err := kmongodriver.QueryOne[T](b.ctx, b.tableName, b.selected, wf, int64(b.limit), int64(b.page), strings.ReplaceAll(b.orderBys, "ORDER BY", ""), b.database)
-	if err != nil {
-		return data, err
-	}
-	if useCache {
-		cachesOneS.Set(c, data)
-	}
-	return data, nil
+	return wf
 }
-
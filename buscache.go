@@ -0,0 +1,195 @@
+package kormongo
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/ksbus"
+)
+
+const (
+	CACHE_STORE_TOPIC = "internal-db-cache-store"
+)
+
+// busCache is a CacheStore keeping the entries in process and sharing them with the other replicas over ksbus
+type busCache struct {
+	id      string
+	local   *lruCache
+	publish func(data map[string]any)
+}
+
+// NewBusCacheStore return a CacheStore sharing its entries with the other replicas connected to bus, a *ksbus.Bus, *ksbus.Server or *ksbus.Client
+// entries are kept in process and invalidations are propagated, a new replica ask the others for their entries so its cache is warm after a deploy
+//
+//	korm.SetCacheStore(korm.NewBusCacheStore(korm.WithBus(ksbus.NewServer())))
+func NewBusCacheStore[B *ksbus.Bus | *ksbus.Server | *ksbus.Client](bus B) CacheStore {
+	c := &busCache{
+		id:    ksbus.GenerateRandomString(12),
+		local: newLRU("shared"),
+	}
+	switch b := any(bus).(type) {
+	case *ksbus.Bus:
+		c.publish = func(data map[string]any) { b.Publish(CACHE_STORE_TOPIC, data) }
+		b.Subscribe(CACHE_STORE_TOPIC, func(data map[string]any, ch ksbus.Channel) { c.handle(data) })
+	case *ksbus.Server:
+		c.publish = func(data map[string]any) { b.Publish(CACHE_STORE_TOPIC, data) }
+		b.Subscribe(CACHE_STORE_TOPIC, func(data map[string]any, ch ksbus.Channel) { c.handle(data) })
+	case *ksbus.Client:
+		c.publish = func(data map[string]any) { b.Publish(CACHE_STORE_TOPIC, data) }
+		b.Subscribe(CACHE_STORE_TOPIC, func(data map[string]any, sub *ksbus.ClientSubscription) { c.handle(data) })
+	}
+	c.send(map[string]any{"type": "sync"})
+	return c
+}
+
+func (c *busCache) send(data map[string]any) {
+	data["from"] = c.id
+	go c.publish(data)
+}
+
+// setMessage return the message sharing an entry, the value is bson encoded then base64 to go through json
+// started is the time the query of the value started, the receivers drop it if they evicted its table since
+func setMessage(key string, value any, expires, started time.Time, tags []string) (map[string]any, error) {
+	b, err := encodeCacheValue(value)
+	if err != nil {
+		return nil, err
+	}
+	var ttl int64
+	if !expires.IsZero() {
+		ttl = time.Until(expires).Milliseconds()
+		if ttl <= 0 {
+			return nil, nil
+		}
+	}
+	tt := make([]any, 0, len(tags))
+	for _, t := range tags {
+		tt = append(tt, t)
+	}
+	return map[string]any{
+		"type":    "set",
+		"key":     key,
+		"value":   base64.StdEncoding.EncodeToString(b),
+		"ttl":     ttl,
+		"started": started.UnixMilli(),
+		"tags":    tt,
+	}, nil
+}
+
+// handle apply the changes made by the other replicas
+func (c *busCache) handle(data map[string]any) {
+	if from, _ := data["from"].(string); from == c.id {
+		return
+	}
+	if to, ok := data["to"].(string); ok && to != c.id {
+		return
+	}
+	key, _ := data["key"].(string)
+	switch data["type"] {
+	case "set":
+		s, _ := data["value"].(string)
+		b, err := base64.StdEncoding.DecodeString(s)
+		if err != nil || key == "" {
+			return
+		}
+		var expires time.Time
+		if ttl := toInt64(data["ttl"]); ttl > 0 {
+			expires = time.Now().Add(time.Duration(ttl) * time.Millisecond)
+		}
+		tags := []string{}
+		switch tt := data["tags"].(type) {
+		case []any:
+			for _, t := range tt {
+				if s, ok := t.(string); ok {
+					tags = append(tags, s)
+				}
+			}
+		case []string:
+			tags = tt
+		}
+		started := time.UnixMilli(toInt64(data["started"]))
+		if len(tags) == 0 {
+			c.local.set(key, b, expires, started, tags)
+			return
+		}
+		// the first tag is the table tag, the entry is dropped if the table was evicted here since its query started
+		database, table := splitTableTag(tags[0])
+		fillRemote(database, table, started, func() { c.local.set(key, b, expires, started, tags) })
+	case "delete":
+		c.local.Delete(key)
+	case "tag":
+		if tag, ok := data["tag"].(string); ok {
+			tt, _, _ := strings.Cut(tag, "#")
+			database, table := splitTableTag(tt)
+			evictTable(database, table, func() { c.local.DeleteByTag(tag) })
+		}
+	case "flush":
+		flushAll(func() { c.local.Flush() })
+	case "sync":
+		from, _ := data["from"].(string)
+		for _, ent := range c.local.entries() {
+			msg, err := setMessage(ent.key, ent.value, ent.expires, ent.started, ent.tags)
+			if err != nil || msg == nil {
+				continue
+			}
+			msg["to"] = from
+			c.send(msg)
+		}
+	}
+}
+
+func (c *busCache) Get(key string) (any, bool) {
+	return c.local.Get(key)
+}
+
+func (c *busCache) getStale(key string) (any, bool, bool) {
+	return c.local.getStale(key)
+}
+
+func (c *busCache) Set(key string, value any, ttl time.Duration, tags ...string) {
+	c.setSince(key, value, ttl, time.Now(), tags...)
+}
+
+func (c *busCache) setSince(key string, value any, ttl time.Duration, started time.Time, tags ...string) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	c.local.set(key, value, expires, started, tags)
+	msg, err := setMessage(key, value, expires, started, tags)
+	if err != nil {
+		logger.Error("bus cache: unable to encode value", "key", key, "error", err)
+		return
+	}
+	if msg != nil {
+		c.send(msg)
+	}
+}
+
+func (c *busCache) Delete(key string) {
+	c.local.Delete(key)
+	c.send(map[string]any{"type": "delete", "key": key})
+}
+
+func (c *busCache) DeleteByTag(tag string) {
+	c.local.DeleteByTag(tag)
+	c.send(map[string]any{"type": "tag", "tag": tag})
+}
+
+func (c *busCache) Flush() {
+	c.local.Flush()
+	c.send(map[string]any{"type": "flush"})
+}
+
+func (c *busCache) localStore() CacheStore {
+	return c.local
+}
+
+func (c *busCache) keys(tag string) []string {
+	return c.local.keys(tag)
+}
+
+// sweep is local, each replica sweep its own entries every FlushCacheEvery
+func (c *busCache) sweep() {
+	c.local.sweep()
+}
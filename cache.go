@@ -0,0 +1,457 @@
+package kormongo
+
+import (
+	"container/list"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kmap"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+var (
+	// CacheMaxEntries is the max number of entries of each in-process query cache, least recently used entries are evicted first, 0 for unlimited
+	CacheMaxEntries = 10000
+	// CacheMaxBytes is the approximate max memory in bytes used by each in-process query cache, 0 for unlimited
+	CacheMaxBytes int64 = 64 << 20
+	cacheLimits         = kmap.New[string, cacheLimit](false)
+)
+
+// CacheStore store the results of All and One, the in-process LRU is used by default, see SetCacheStore
+type CacheStore interface {
+	// Get return the value of key, values set by another process are returned bson encoded as []byte
+	Get(key string) (any, bool)
+	// Set store value for ttl, 0 keep it until the next FlushCacheEvery sweep, tags are used by DeleteByTag
+	Set(key string, value any, ttl time.Duration, tags ...string)
+	Delete(key string)
+	// DeleteByTag delete all the entries set with tag
+	DeleteByTag(tag string)
+	Flush()
+}
+
+// SetCacheStore replace the in-process caches of All and One of both builders by store, should be called before korm.New
+func SetCacheStore(store CacheStore) {
+	cachesAllM = namedStore{name: "all_m", store: store}
+	cachesOneM = namedStore{name: "one_m", store: store}
+	cachesAllS = namedStore{name: "all_s", store: store}
+	cachesOneS = namedStore{name: "one_s", store: store}
+}
+
+// namedStore prefix the keys of a store shared by the query caches
+type namedStore struct {
+	name  string
+	store CacheStore
+}
+
+func (s namedStore) Get(key string) (any, bool) {
+	return s.store.Get(s.name + ":" + key)
+}
+
+func (s namedStore) getStale(key string) (any, bool, bool) {
+	return lookupCache(s.store, s.name+":"+key)
+}
+
+func (s namedStore) Set(key string, value any, ttl time.Duration, tags ...string) {
+	s.store.Set(s.name+":"+key, value, ttl, tags...)
+}
+
+func (s namedStore) Delete(key string) {
+	s.store.Delete(s.name + ":" + key)
+}
+
+func (s namedStore) DeleteByTag(tag string) {
+	s.store.DeleteByTag(tag)
+}
+
+func (s namedStore) Flush() {
+	s.store.Flush()
+}
+
+func (s namedStore) sweep() {
+	if sw, ok := s.store.(interface{ sweep() }); ok {
+		sw.sweep()
+	}
+}
+
+func (s namedStore) setSince(key string, value any, ttl time.Duration, started time.Time, tags ...string) {
+	storeSet(s.store, s.name+":"+key, value, ttl, started, tags...)
+}
+
+// keys return the keys of the store prefixed by the name, without the prefix
+func (s namedStore) keys(tag string) []string {
+	res := []string{}
+	for _, key := range storeKeys(s.store, tag) {
+		if strings.HasPrefix(key, s.name+":") {
+			res = append(res, strings.TrimPrefix(key, s.name+":"))
+		}
+	}
+	return res
+}
+
+// storeSet set the entry of key, giving the time its query started to the stores sharing their entries with other replicas
+func storeSet(store CacheStore, key string, value any, ttl time.Duration, started time.Time, tags ...string) {
+	if s, ok := store.(interface {
+		setSince(key string, value any, ttl time.Duration, started time.Time, tags ...string)
+	}); ok {
+		s.setSince(key, value, ttl, started, tags...)
+		return
+	}
+	store.Set(key, value, ttl, tags...)
+}
+
+// tableTag is the tag of all the entries of a table
+func tableTag(database, table string) string {
+	return database + "." + table
+}
+
+// splitTableTag return the database and table of a table tag, database names cannot contain '.'
+func splitTableTag(tag string) (string, string) {
+	database, table, _ := strings.Cut(tag, ".")
+	return database, table
+}
+
+// cacheValue return v as V, decoding it if it was set by another process
+func cacheValue[V any](v any) (V, bool) {
+	switch vv := v.(type) {
+	case V:
+		return vv, true
+	case []byte:
+		res := struct {
+			V V `bson:"v"`
+		}{}
+		if err := bson.Unmarshal(vv, &res); err != nil {
+			return *new(V), false
+		}
+		return res.V, true
+	}
+	return *new(V), false
+}
+
+// encodeCacheValue encode v to be stored outside of the process
+func encodeCacheValue(v any) ([]byte, error) {
+	if b, ok := v.([]byte); ok {
+		return b, nil
+	}
+	return bson.Marshal(bson.M{"v": v})
+}
+
+type cacheLimit struct {
+	maxEntries int
+	maxBytes   int64
+}
+
+// SetCacheLimits override CacheMaxEntries and CacheMaxBytes for the in-process query cache name: 'all_m', 'one_m', 'all_s' or 'one_s', 0 for unlimited
+func SetCacheLimits(name string, maxEntries int, maxBytes int64) error {
+	var store CacheStore
+	switch name {
+	case "all_m":
+		store = cachesAllM
+	case "one_m":
+		store = cachesOneM
+	case "all_s":
+		store = cachesAllS
+	case "one_s":
+		store = cachesOneS
+	default:
+		return errors.New("unknown cache " + name + ", use all_m, one_m, all_s or one_s")
+	}
+	cacheLimits.Set(name, cacheLimit{maxEntries: maxEntries, maxBytes: maxBytes})
+	if c, ok := store.(*lruCache); ok {
+		c.evict()
+	}
+	return nil
+}
+
+// lruCache is the in-process CacheStore, bounded in entries and approximate bytes, evicting the least recently used entries
+type lruCache struct {
+	name  string
+	mu    sync.Mutex
+	ll    *list.List
+	items map[string]*list.Element
+	tags  map[string]map[string]struct{}
+	bytes int64
+}
+
+type lruEntry struct {
+	key     string
+	value   any
+	size    int64
+	expires time.Time
+	started time.Time // when the query of the value started
+	tags    []string
+}
+
+func (ent *lruEntry) expired() bool {
+	return !ent.expires.IsZero() && time.Now().After(ent.expires)
+}
+
+// NewMemoryCacheStore return an in-process CacheStore limited by CacheMaxEntries and CacheMaxBytes, it's the default store
+func NewMemoryCacheStore() CacheStore {
+	return newLRU("memory")
+}
+
+func newLRU(name string) *lruCache {
+	return &lruCache{
+		name:  name,
+		ll:    list.New(),
+		items: map[string]*list.Element{},
+		tags:  map[string]map[string]struct{}{},
+	}
+}
+
+func (c *lruCache) limits() (int, int64) {
+	if lim, ok := cacheLimits.Get(c.name); ok {
+		return lim.maxEntries, lim.maxBytes
+	}
+	return CacheMaxEntries, CacheMaxBytes
+}
+
+// Get return the value of key if found and not expired
+func (c *lruCache) Get(key string) (any, bool) {
+	v, stale, ok := c.getStale(key)
+	if stale {
+		return nil, false
+	}
+	return v, ok
+}
+
+// getStale return the value of key, stale is true if it expired since less than StaleWhileRevalidate
+func (c *lruCache) getStale(key string) (any, bool, bool) {
+	c.mu.Lock()
+	e, ok := c.items[key]
+	if !ok {
+		c.mu.Unlock()
+		return nil, false, false
+	}
+	ent := e.Value.(*lruEntry)
+	if ent.expired() {
+		if time.Since(ent.expires) < StaleWhileRevalidate {
+			c.mu.Unlock()
+			return ent.value, true, true
+		}
+		c.removeElement(e)
+		c.mu.Unlock()
+		c.recordEvictions([]*lruEntry{ent})
+		return nil, false, false
+	}
+	c.ll.MoveToFront(e)
+	c.mu.Unlock()
+	return ent.value, false, true
+}
+
+// Set add or replace the entry of key, values bigger than the max bytes are not cached
+func (c *lruCache) Set(key string, value any, ttl time.Duration, tags ...string) {
+	var expires time.Time
+	if ttl > 0 {
+		expires = time.Now().Add(ttl)
+	}
+	c.set(key, value, expires, time.Now(), tags)
+}
+
+func (c *lruCache) set(key string, value any, expires, started time.Time, tags []string) {
+	size := int64(len(key)) + approxSize(value)
+	if _, maxBytes := c.limits(); maxBytes > 0 && size > maxBytes {
+		c.Delete(key)
+		return
+	}
+	c.mu.Lock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+	c.items[key] = c.ll.PushFront(&lruEntry{key: key, value: value, size: size, expires: expires, started: started, tags: tags})
+	c.bytes += size
+	for _, t := range tags {
+		if c.tags[t] == nil {
+			c.tags[t] = map[string]struct{}{}
+		}
+		c.tags[t][key] = struct{}{}
+	}
+	c.mu.Unlock()
+	c.evict()
+}
+
+// evict remove the least recently used entries until the cache fit its limits
+func (c *lruCache) evict() {
+	maxEntries, maxBytes := c.limits()
+	evicted := []*lruEntry{}
+	c.mu.Lock()
+	for c.ll.Len() > 0 && ((maxEntries > 0 && c.ll.Len() > maxEntries) || (maxBytes > 0 && c.bytes > maxBytes)) {
+		evicted = append(evicted, c.removeElement(c.ll.Back()))
+	}
+	c.mu.Unlock()
+	c.recordEvictions(evicted)
+}
+
+// sweep remove the expired entries and those without their own ttl, with StaleWhileRevalidate the latter are only marked as expired
+func (c *lruCache) sweep() {
+	now := time.Now()
+	c.removeIf(func(ent *lruEntry) bool {
+		if ent.expires.IsZero() && StaleWhileRevalidate > 0 {
+			ent.expires = now
+			return false
+		}
+		return ent.expires.IsZero() || (ent.expired() && now.Sub(ent.expires) >= StaleWhileRevalidate)
+	})
+}
+
+func (c *lruCache) removeIf(fn func(ent *lruEntry) bool) {
+	evicted := []*lruEntry{}
+	c.mu.Lock()
+	for e := c.ll.Front(); e != nil; {
+		next := e.Next()
+		if fn(e.Value.(*lruEntry)) {
+			evicted = append(evicted, c.removeElement(e))
+		}
+		e = next
+	}
+	c.mu.Unlock()
+	c.recordEvictions(evicted)
+}
+
+func (c *lruCache) removeElement(e *list.Element) *lruEntry {
+	ent := c.ll.Remove(e).(*lruEntry)
+	delete(c.items, ent.key)
+	for _, t := range ent.tags {
+		delete(c.tags[t], ent.key)
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
+	c.bytes -= ent.size
+	return ent
+}
+
+func (c *lruCache) recordEvictions(entries []*lruEntry) {
+	for _, ent := range entries {
+		if len(ent.tags) > 0 {
+			database, table := splitTableTag(ent.tags[0])
+			recordCacheEviction(c.name, database, table, 1)
+		}
+	}
+}
+
+func (c *lruCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[key]; ok {
+		c.removeElement(e)
+	}
+}
+
+func (c *lruCache) DeleteByTag(tag string) {
+	evicted := []*lruEntry{}
+	c.mu.Lock()
+	for key := range c.tags[tag] {
+		if e, ok := c.items[key]; ok {
+			evicted = append(evicted, c.removeElement(e))
+		}
+	}
+	c.mu.Unlock()
+	c.recordEvictions(evicted)
+}
+
+func (c *lruCache) Flush() {
+	c.removeIf(func(*lruEntry) bool { return true })
+}
+
+// entries return a snapshot of the entries not expired, most recently used first
+func (c *lruCache) entries() []*lruEntry {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]*lruEntry, 0, c.ll.Len())
+	for e := c.ll.Front(); e != nil; e = e.Next() {
+		if ent := e.Value.(*lruEntry); !ent.expired() {
+			res = append(res, ent)
+		}
+	}
+	return res
+}
+
+// keys return the keys of the entries tagged with tag, all of them if tag is empty
+func (c *lruCache) keys(tag string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := []string{}
+	if tag == "" {
+		for key := range c.items {
+			res = append(res, key)
+		}
+		return res
+	}
+	for key := range c.tags[tag] {
+		res = append(res, key)
+	}
+	return res
+}
+
+func (c *lruCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
+
+// Bytes return the approximate memory used by the entries
+func (c *lruCache) Bytes() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.bytes
+}
+
+// approxSize return an approximation of the memory used by v in bytes
+func approxSize(v any) int64 {
+	return sizeOf(reflect.ValueOf(v), 0)
+}
+
+func sizeOf(v reflect.Value, depth int) int64 {
+	if !v.IsValid() {
+		return 0
+	}
+	if depth > 32 {
+		return int64(v.Type().Size())
+	}
+	if v.Type() == timeType {
+		return int64(v.Type().Size())
+	}
+	switch v.Kind() {
+	case reflect.String:
+		return int64(v.Type().Size()) + int64(v.Len())
+	case reflect.Slice:
+		n := int64(v.Type().Size())
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return n + int64(v.Len())
+		}
+		for i := 0; i < v.Len(); i++ {
+			n += sizeOf(v.Index(i), depth+1)
+		}
+		return n
+	case reflect.Array:
+		n := int64(0)
+		for i := 0; i < v.Len(); i++ {
+			n += sizeOf(v.Index(i), depth+1)
+		}
+		return n
+	case reflect.Map:
+		n := int64(48)
+		it := v.MapRange()
+		for it.Next() {
+			n += sizeOf(it.Key(), depth+1) + sizeOf(it.Value(), depth+1)
+		}
+		return n
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return int64(v.Type().Size())
+		}
+		return int64(v.Type().Size()) + sizeOf(v.Elem(), depth+1)
+	case reflect.Struct:
+		n := int64(0)
+		for i := 0; i < v.NumField(); i++ {
+			n += sizeOf(v.Field(i), depth+1)
+		}
+		return n
+	default:
+		return int64(v.Type().Size())
+	}
+}
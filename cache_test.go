@@ -0,0 +1,258 @@
+package kormongo
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// memTable is a table in memory standing for mongo, reads are slowed so they overlap the writes
+type memTable struct {
+	mu   sync.Mutex
+	docs map[int]int
+}
+
+func (t *memTable) read(ctx context.Context) ([]map[string]any, error) {
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	t.mu.Lock()
+	res := make([]map[string]any, 0, len(t.docs))
+	for id, v := range t.docs {
+		res = append(res, map[string]any{"_id": id, "v": v})
+	}
+	t.mu.Unlock()
+	time.Sleep(time.Duration(rand.Intn(200)) * time.Microsecond)
+	return res, nil
+}
+
+func (t *memTable) write(fn func(docs map[int]int)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	fn(t.docs)
+}
+
+// cachedAll is the All of the builders on memTable
+func cachedAll(t *testing.T, store CacheStore, table *memTable, database, tableName string) []map[string]any {
+	key, err := queryKey(database, tableName, "map", nil, "", "", 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gen := cacheGeneration(database, tableName)
+	res, _, err := cachedQuery(context.Background(), "test_all", store, key, gen, false, table.read, func(res []map[string]any) {
+		fillCache(database, tableName, gen, func() {
+			store.Set(key, res, 0, cacheTags(database, tableName, nil, "", false, res)...)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res
+}
+
+func findDoc(docs []map[string]any, id int) (int, bool) {
+	for _, d := range docs {
+		if d["_id"] == id {
+			return d["v"].(int), true
+		}
+	}
+	return 0, false
+}
+
+// TestCacheNoStaleFill run reads concurrently with inserts, updates and deletes, a read done after a write must always see it
+func TestCacheNoStaleFill(t *testing.T) {
+	const database, tableName = "test", "race_mem"
+	store := cachesAllM
+	table := &memTable{docs: map[int]int{}}
+	stop := make(chan struct{})
+	readers := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					cachedAll(t, store, table, database, tableName)
+				}
+			}
+		}()
+	}
+
+	writers := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < 100; i++ {
+				// each writer own its documents, so what it wrote is what a read following it must return
+				id := w*1000 + i
+				table.write(func(docs map[int]int) { docs[id] = 0 })
+				invalidate("create", database, tableName, insertTags(database, tableName, map[string]any{"_id": id, "v": 0}))
+				if _, ok := findDoc(cachedAll(t, store, table, database, tableName), id); !ok {
+					t.Errorf("document %d inserted but not returned", id)
+					return
+				}
+
+				table.write(func(docs map[int]int) { docs[id] = 1 })
+				invalidateWrite("update", database, tableName, id, nil, []string{"v"}, false)
+				if v, _ := findDoc(cachedAll(t, store, table, database, tableName), id); v != 1 {
+					t.Errorf("document %d updated but %d returned", id, v)
+					return
+				}
+
+				if i%2 == 0 {
+					table.write(func(docs map[int]int) { delete(docs, id) })
+					invalidateWrite("delete", database, tableName, id, nil, nil, true)
+					if _, ok := findDoc(cachedAll(t, store, table, database, tableName), id); ok {
+						t.Errorf("document %d deleted but returned", id)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+
+	want, _ := table.read(context.Background())
+	got := cachedAll(t, store, table, database, tableName)
+	if len(got) != len(want) {
+		t.Fatalf("cache hold %d documents, the table %d", len(got), len(want))
+	}
+	for _, d := range want {
+		if v, ok := findDoc(got, d["_id"].(int)); !ok || v != d["v"] {
+			t.Fatalf("cache hold %v for document %v", v, d)
+		}
+	}
+}
+
+// TestCacheFlushRejectFill check a query started before a flush does not fill the cache after it
+func TestCacheFlushRejectFill(t *testing.T) {
+	const database, tableName = "test", "race_flush"
+	gen := cacheGeneration(database, tableName)
+	flushQueryCaches()
+	if fillCache(database, tableName, gen, func() {}) {
+		t.Fatal("fill started before the flush accepted")
+	}
+	gen = cacheGeneration(database, tableName)
+	evictTags(database, tableName, nil)
+	if fillCache(database, tableName, gen, func() {}) {
+		t.Fatal("fill started before the eviction accepted")
+	}
+	if !fillCache(database, tableName, cacheGeneration(database, tableName), func() {}) {
+		t.Fatal("fill started after the eviction rejected")
+	}
+}
+
+type raceDoc struct {
+	Id    primitive.ObjectID `bson:"_id,omitempty"`
+	Owner int                `bson:"owner"`
+	V     int                `bson:"v"`
+}
+
+// TestBuilderNoStaleAll is TestCacheNoStaleFill using the builders, it need a mongo server at KORMONGO_TEST_DSN, ex: localhost:27017
+func TestBuilderNoStaleAll(t *testing.T) {
+	dsn := os.Getenv("KORMONGO_TEST_DSN")
+	if dsn == "" {
+		t.Skip("KORMONGO_TEST_DSN not set")
+	}
+	const database, tableName = "kormongo_test", "race_docs"
+	if err := New(database, dsn); err != nil {
+		t.Fatal(err)
+	}
+	db, err := GetMemoryDatabase(database)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := AutoMigrate[raceDoc](tableName, database); err != nil {
+		t.Fatal(err)
+	}
+	defer Model[raceDoc]().Database(database).Drop()
+	if _, err := db.MongoConn.Collection(tableName).DeleteMany(context.Background(), bson.M{}); err != nil {
+		t.Fatal(err)
+	}
+
+	stop := make(chan struct{})
+	readers := sync.WaitGroup{}
+	var reads atomic.Int64
+	for i := 0; i < 8; i++ {
+		readers.Add(1)
+		go func() {
+			defer readers.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					if _, err := Model[raceDoc]().Database(database).All(); err != nil {
+						t.Error(err)
+						return
+					}
+					reads.Add(1)
+				}
+			}
+		}()
+	}
+
+	find := func(owner int) (raceDoc, bool) {
+		docs, err := Model[raceDoc]().Database(database).All()
+		if err != nil {
+			t.Error(err)
+		}
+		for _, d := range docs {
+			if d.Owner == owner {
+				return d, true
+			}
+		}
+		return raceDoc{}, false
+	}
+	writers := sync.WaitGroup{}
+	for w := 0; w < 4; w++ {
+		writers.Add(1)
+		go func(w int) {
+			defer writers.Done()
+			for i := 0; i < 20; i++ {
+				if _, err := Model[raceDoc]().Database(database).Insert(&raceDoc{Owner: w}); err != nil {
+					t.Error(err)
+					return
+				}
+				if _, ok := find(w); !ok {
+					t.Errorf("owner %d inserted but not returned", w)
+					return
+				}
+				if _, err := Model[raceDoc]().Database(database).Where("owner", w).Set("v = ?", i+1); err != nil {
+					t.Error(err)
+					return
+				}
+				if d, _ := find(w); d.V != i+1 {
+					t.Errorf("owner %d set to %d but %d returned", w, i+1, d.V)
+					return
+				}
+				if _, err := Model[raceDoc]().Database(database).Where("owner", w).Delete(); err != nil {
+					t.Error(err)
+					return
+				}
+				if d, ok := find(w); ok {
+					t.Errorf("owner %d deleted but %s returned", w, fmt.Sprint(d))
+					return
+				}
+			}
+		}(w)
+	}
+	writers.Wait()
+	close(stop)
+	readers.Wait()
+	if reads.Load() == 0 {
+		t.Fatal("no concurrent read done")
+	}
+}
@@ -0,0 +1,100 @@
+package kormongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sort"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// queryKey return the key of a query in the CacheStore, the hash of the canonical bson of everything that change its result
+// equal filters give the same key whatever the order of their maps, values keep their bson type so 5 and "5" never share an entry
+func queryKey(database, table, model string, filter map[string]any, projection, sort string, limit, page int) (string, error) {
+	q := bson.D{
+		{Key: "model", Value: model},
+		{Key: "filter", Value: canonicalValue(filter)},
+		{Key: "projection", Value: projectionFields(projection)},
+		{Key: "sort", Value: sortDoc(sort)},
+		{Key: "limit", Value: limit},
+		{Key: "page", Value: page},
+	}
+	b, err := bson.Marshal(q)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.Sum256(b)
+	return tableTag(database, table) + ":" + hex.EncodeToString(h[:]), nil
+}
+
+// modelKey identify the type T results are decoded into
+func modelKey[T any]() string {
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if t.Name() == "" {
+		return t.String()
+	}
+	return t.PkgPath() + "." + t.Name()
+}
+
+// projectionFields return the selected fields sorted, the projection does not depend on their order
+func projectionFields(selected string) []string {
+	fields := []string{}
+	for _, f := range strings.Split(selected, ",") {
+		if f = strings.TrimSpace(f); f != "" {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+// canonicalValue return v with its maps as bson.D sorted by key, recursively, bson.D keep their order which is significant to mongo
+func canonicalValue(v any) any {
+	switch vv := v.(type) {
+	case nil:
+		return nil
+	case bson.D:
+		res := make(bson.D, 0, len(vv))
+		for _, e := range vv {
+			res = append(res, bson.E{Key: e.Key, Value: canonicalValue(e.Value)})
+		}
+		return res
+	case []byte, primitive.ObjectID, primitive.Binary:
+		return vv
+	}
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Pointer:
+		if rv.IsNil() {
+			return nil
+		}
+		return canonicalValue(rv.Elem().Interface())
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			return v
+		}
+		keys := make([]string, 0, rv.Len())
+		for _, k := range rv.MapKeys() {
+			keys = append(keys, k.String())
+		}
+		sort.Strings(keys)
+		res := make(bson.D, 0, len(keys))
+		for _, k := range keys {
+			res = append(res, bson.E{Key: k, Value: canonicalValue(rv.MapIndex(reflect.ValueOf(k).Convert(rv.Type().Key())).Interface())})
+		}
+		return res
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return nil
+		}
+		res := make(bson.A, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			res = append(res, canonicalValue(rv.Index(i).Interface()))
+		}
+		return res
+	}
+	return v
+}
@@ -0,0 +1,154 @@
+package kormongo
+
+import (
+	"net/url"
+	"sort"
+
+	"github.com/kamalshkeir/kmux/ws"
+	"github.com/kamalshkeir/ksbus"
+)
+
+// CacheStat is the state of a query cache, or of the entries of one table in it when Table is set
+type CacheStat struct {
+	Cache     string
+	Database  string
+	Table     string
+	Entries   int
+	Bytes     int64 // approximate, in-process caches only
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+}
+
+// queryCacheNames are the names of the query caches, as used by SetCacheLimits and the metrics
+var queryCacheNames = []string{"all_m", "one_m", "all_s", "one_s"}
+
+// queryCache return the query cache name
+func queryCache(name string) CacheStore {
+	switch name {
+	case "all_m":
+		return cachesAllM
+	case "one_m":
+		return cachesOneM
+	case "all_s":
+		return cachesAllS
+	case "one_s":
+		return cachesOneS
+	}
+	return nil
+}
+
+// storeKeys return the keys tagged with tag of the stores that can list them, all of them if tag is empty
+func storeKeys(store CacheStore, tag string) []string {
+	if s, ok := store.(interface{ keys(tag string) []string }); ok {
+		return s.keys(tag)
+	}
+	return nil
+}
+
+// CacheStats return the entries, hits, misses and evictions of each query cache, followed by those of each table in it
+// hits, misses and evictions are counted since the start of the process, entries are counted only for the stores that can list them
+// the caches are those of the calling process, expose it from the app to inspect them, ex: in an admin handler
+func CacheStats() []CacheStat {
+	_, bytes := cacheSizes()
+	metricsMu.Lock()
+	counters := make(map[cacheKey]cacheCounters, len(cacheMetrics))
+	for k, c := range cacheMetrics {
+		counters[k] = *c
+	}
+	metricsMu.Unlock()
+
+	res := []CacheStat{}
+	for _, name := range queryCacheNames {
+		store := queryCache(name)
+		total := CacheStat{Cache: name, Entries: len(storeKeys(store, "")), Bytes: bytes[name]}
+		tables := []CacheStat{}
+		for k, c := range counters {
+			if k.cache != name {
+				continue
+			}
+			total.Hits += c.hits
+			total.Misses += c.misses
+			total.Evictions += c.evictions
+			tables = append(tables, CacheStat{
+				Cache:     name,
+				Database:  k.database,
+				Table:     k.table,
+				Entries:   len(storeKeys(store, tableTag(k.database, k.table))),
+				Hits:      c.hits,
+				Misses:    c.misses,
+				Evictions: c.evictions,
+			})
+		}
+		sort.Slice(tables, func(i, j int) bool {
+			return tables[i].Database+"."+tables[i].Table < tables[j].Database+"."+tables[j].Table
+		})
+		res = append(res, total)
+		res = append(res, tables...)
+	}
+	return res
+}
+
+// CacheKeys return the keys of the cached queries of table in the calling process, prefixed by the name of their cache
+func CacheKeys(table string, dbName ...string) []string {
+	database := ""
+	if len(dbName) > 0 {
+		database = dbName[0]
+	} else if len(databases) > 0 {
+		database = databases[0].Name
+	}
+	res := []string{}
+	for _, name := range queryCacheNames {
+		for _, key := range storeKeys(queryCache(name), tableTag(database, table)) {
+			res = append(res, name+":"+key)
+		}
+	}
+	sort.Strings(res)
+	return res
+}
+
+// InvalidateTable evict the cached queries of a table, in this process and the ones sharing its cache bus
+// useful after writing to the collection without the builders, FlushCache evict all the tables
+func InvalidateTable(database, table string) {
+	invalidate("update", database, table, nil)
+}
+
+// InvalidateTableAt evict the cached queries of a table in the app running the ksbus server given to WithBus or ClusterCache at addr, ex: "localhost:9313"
+// the event is also received by the replicas connected to that server, table '*' flush all the caches like FlushCache
+func InvalidateTableAt(addr, database, table string) error {
+	// a new origin, the calling process evict its caches too if it is connected to the app
+	from := ksbus.GenerateRandomString(12)
+	data := map[string]any{
+		"type":     "update",
+		"database": database,
+		"table":    table,
+		"from":     from,
+	}
+	if table == "*" {
+		data = map[string]any{
+			"type": "clean",
+			"from": from,
+		}
+	}
+	// the message of ksbus.Client.Publish, sent without a ksbus client: its Close race with its reader and nothing is read here
+	sch := "ws"
+	if ClusterSecure {
+		sch = "wss"
+	}
+	u := url.URL{Scheme: sch, Host: addr, Path: ksbus.ServerPath}
+	conn, _, err := ws.DefaultDialer.Dial(u.String(), nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	err = conn.WriteJSON(map[string]any{
+		"action": "pub",
+		"topic":  CACHE_TOPIC,
+		"data":   data,
+		"id":     from,
+	})
+	if err != nil {
+		return err
+	}
+	return conn.WriteMessage(ws.CloseMessage, ws.FormatCloseMessage(ws.CloseNormalClosure, ""))
+}
@@ -0,0 +1,123 @@
+package kormongo
+
+import (
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/kmap"
+	"github.com/kamalshkeir/ksbus"
+)
+
+var (
+	// ClusterHeartbeat is the interval of the pings sent to the peers of ClusterCache, a peer not answering for 3 of them is reconnected
+	ClusterHeartbeat = 5 * time.Second
+	// ClusterSecure connect to the peers of ClusterCache using wss
+	ClusterSecure = false
+	clusterPeers  = kmap.New[string, *clusterPeer](false)
+)
+
+// clusterPeer is the connection to the ksbus server of another replica, receiving its cache events
+type clusterPeer struct {
+	addr     string
+	mu       sync.Mutex
+	client   *ksbus.Client
+	lastSeen time.Time
+	down     bool
+}
+
+// ClusterCache share the cache invalidations of the replicas of an app, each one run bus and connect to the others at peers, ex: "10.0.0.2:9313"
+// the events of a replica are published on its own server and received by the clients of the others, so peers can contain the replica itself
+// a peer not responding is reconnected every ClusterHeartbeat, and the query caches are flushed on reconnection since its events were missed
+// use it instead of WithBus
+//
+//	korm.ClusterCache(ksbus.NewServer(), "10.0.0.2:9313", "10.0.0.3:9313").Run(":9313")
+func ClusterCache(bus *ksbus.Server, peers ...string) *ksbus.Server {
+	WithBus(bus)
+	if !useCache {
+		return bus
+	}
+	for _, addr := range peers {
+		if _, ok := clusterPeers.Get(addr); ok || addr == "" {
+			continue
+		}
+		p := &clusterPeer{addr: addr}
+		clusterPeers.Set(addr, p)
+		go p.run()
+	}
+	return bus
+}
+
+// run keep the connection to the peer alive, pinging it every ClusterHeartbeat
+func (p *clusterPeer) run() {
+	for {
+		p.mu.Lock()
+		client, lastSeen := p.client, p.lastSeen
+		p.mu.Unlock()
+		if client != nil && time.Since(lastSeen) > 3*ClusterHeartbeat {
+			logger.Warn("cache cluster: peer not responding", "peer", p.addr)
+			p.mu.Lock()
+			p.client = nil
+			p.down = true
+			p.mu.Unlock()
+			go client.Close()
+			client = nil
+		}
+		if client == nil {
+			p.connect()
+		} else {
+			client.Publish(CACHE_TOPIC, map[string]any{
+				"type": "ping",
+				"from": cacheOrigin,
+				"peer": p.addr,
+			})
+		}
+		time.Sleep(ClusterHeartbeat)
+	}
+}
+
+// connect subscribe to the cache events of the peer
+func (p *clusterPeer) connect() {
+	client, err := ksbus.NewClient(p.addr, ClusterSecure)
+	if err != nil {
+		p.mu.Lock()
+		if !p.down {
+			logger.Warn("cache cluster: peer unreachable", "peer", p.addr, "error", err)
+			p.down = true
+		}
+		p.mu.Unlock()
+		return
+	}
+	client.Subscribe(CACHE_TOPIC, func(data map[string]any, sub *ksbus.ClientSubscription) { handleClusterMessage(data) })
+	p.mu.Lock()
+	p.client = client
+	p.lastSeen = time.Now()
+	reconnected := p.down
+	p.down = false
+	p.mu.Unlock()
+	if reconnected {
+		logger.Info("cache cluster: peer reconnected", "peer", p.addr)
+		// the writes done by the peer while it was unreachable were not received
+		cacheGetAllTables.Flush()
+		flushQueryCaches()
+	}
+}
+
+// handleClusterMessage handle the cache events received from a peer, its pings are answered by its own server
+func handleClusterMessage(data map[string]any) {
+	switch data["type"] {
+	case "ping":
+		return
+	case "pong":
+		if to, _ := data["to"].(string); to != cacheOrigin {
+			return
+		}
+		addr, _ := data["peer"].(string)
+		if p, ok := clusterPeers.Get(addr); ok {
+			p.mu.Lock()
+			p.lastSeen = time.Now()
+			p.mu.Unlock()
+		}
+		return
+	}
+	handleCache(data)
+}
@@ -0,0 +1,197 @@
+package kormongo
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kamalshkeir/ksbus"
+)
+
+// ksbus and kmux keep their state in globals, so each replica of these tests is a process: the test itself and helpers running TestClusterReplica
+
+// TestClusterReplica is a replica run by the cluster tests, it call invalidate for each line "kind database table tags..." read on stdin
+func TestClusterReplica(t *testing.T) {
+	addr := os.Getenv("KORMONGO_CLUSTER_REPLICA")
+	if addr == "" {
+		t.Skip("run by the cluster tests")
+	}
+	ClusterHeartbeat = 100 * time.Millisecond
+	bus := ClusterCache(ksbus.NewServer(), strings.Split(os.Getenv("KORMONGO_CLUSTER_PEERS"), ",")...)
+	go bus.Run(addr)
+	sc := bufio.NewScanner(os.Stdin)
+	for sc.Scan() {
+		f := strings.Fields(sc.Text())
+		if len(f) < 3 {
+			continue
+		}
+		invalidate(f[0], f[1], f[2], f[3:])
+	}
+}
+
+type clusterReplica struct {
+	addr, peers string
+	cmd         *exec.Cmd
+	stdin       io.WriteCloser
+}
+
+func (r *clusterReplica) start(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-test.run=^TestClusterReplica$")
+	cmd.Env = append(os.Environ(), "KORMONGO_CLUSTER_REPLICA="+r.addr, "KORMONGO_CLUSTER_PEERS="+r.peers)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	r.cmd, r.stdin = cmd, stdin
+}
+
+func (r *clusterReplica) stop() {
+	r.cmd.Process.Kill()
+	r.cmd.Wait()
+}
+
+// write make the replica invalidate as after a write
+func (r *clusterReplica) write(t *testing.T, kind, database, table string, tags ...string) {
+	if _, err := fmt.Fprintln(r.stdin, kind, database, table, strings.Join(tags, " ")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func loopbackAddr(t *testing.T) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// eventually fail if cond is not true within 5s
+func eventually(t *testing.T, msg string, cond func() bool) {
+	t.Helper()
+	for start := time.Now(); time.Since(start) < 5*time.Second; time.Sleep(20 * time.Millisecond) {
+		if cond() {
+			return
+		}
+	}
+	t.Fatal(msg)
+}
+
+func peerState(addr string) (connected, down bool) {
+	p, ok := clusterPeers.Get(addr)
+	if !ok {
+		return false, false
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.client != nil && time.Since(p.lastSeen) < 2*ClusterHeartbeat, p.down
+}
+
+func cached(key string) bool {
+	_, ok := cachesAllM.Get(key)
+	return ok
+}
+
+// clusterStarted is set by TestClusterCache, the peers of ClusterCache cannot be stopped so it run once per process, ex: with -count
+var clusterStarted bool
+
+func TestClusterCache(t *testing.T) {
+	if clusterStarted {
+		t.Skip("ClusterCache already started by a previous run")
+	}
+	clusterStarted = true
+	const database, table = "test", "cluster"
+	self, addr2, addr3 := loopbackAddr(t), loopbackAddr(t), loopbackAddr(t)
+	ClusterHeartbeat = 100 * time.Millisecond
+	// the replica is in its own peers, its events come back to it
+	bus := ClusterCache(ksbus.NewServer(), self, addr2, addr3)
+	go bus.Run(self)
+	// the helpers don't subscribe to this replica, ksbus v0.3.0 race in the server a websocket client disconnect from
+	r2 := &clusterReplica{addr: addr2, peers: addr3}
+	r3 := &clusterReplica{addr: addr3, peers: addr2}
+	r2.start(t)
+	defer r2.stop()
+	r3.start(t)
+	defer r3.stop()
+	for _, addr := range []string{self, addr2, addr3} {
+		eventually(t, "peer "+addr+" not connected", func() bool {
+			ok, _ := peerState(addr)
+			return ok
+		})
+	}
+	time.Sleep(2 * ClusterHeartbeat)
+
+	t.Run("propagation", func(t *testing.T) {
+		for _, kind := range []string{"create", "update", "delete"} {
+			cachesAllM.Set("all", 1, 0, tableTag(database, table), flagTag(database, table, "all"))
+			cachesAllM.Set("one", 1, 0, tableTag(database, table), idTag(database, table, 1))
+			cachesAllM.Set("other", 1, 0, tableTag(database, "other"))
+			tags := []string{flagTag(database, table, "all")}
+			if kind != "create" {
+				tags = []string{flagTag(database, table, "noid"), idTag(database, table, 1)}
+			}
+			r2.write(t, kind, database, table, tags...)
+			evicted := "one"
+			if kind == "create" {
+				evicted = "all"
+			}
+			eventually(t, kind+" of a replica not received", func() bool { return !cached(evicted) })
+			if !cached("other") {
+				t.Fatalf("%s evicted the entries of another table", kind)
+			}
+		}
+		cachesAllM.Set("other", 1, 0, tableTag(database, "other"))
+		r3.write(t, "drop", database, table)
+		eventually(t, "drop of a replica not received", func() bool { return !cached("other") })
+	})
+
+	t.Run("self", func(t *testing.T) {
+		tag := idTag(database, table, 2)
+		invalidate("update", database, table, []string{tag})
+		// filled after the write, the echo of its own event must not evict it
+		cachesAllM.Set("self", 1, 0, tableTag(database, table), tag)
+		time.Sleep(5 * ClusterHeartbeat)
+		if !cached("self") {
+			t.Fatal("own event evicted an entry filled after it")
+		}
+	})
+
+	t.Run("remote", func(t *testing.T) {
+		// as the shell 'cache flush', run in another process than the app
+		cachesAllM.Set("remote", 1, 0, tableTag(database, table))
+		if err := InvalidateTableAt(addr2, database, table); err != nil {
+			t.Fatal(err)
+		}
+		eventually(t, "flush of a replica not propagated", func() bool { return !cached("remote") })
+	})
+
+	t.Run("reconnect", func(t *testing.T) {
+		r3.stop()
+		eventually(t, "stopped peer not detected", func() bool {
+			_, down := peerState(addr3)
+			return down
+		})
+		// the other replicas keep propagating their events
+		cachesAllM.Set("up", 1, 0, tableTag(database, table), idTag(database, table, 3))
+		r2.write(t, "update", database, table, idTag(database, table, 3))
+		eventually(t, "event lost while a peer is down", func() bool { return !cached("up") })
+
+		// the writes of the stopped peer were missed, its reconnection flush the caches
+		cachesAllM.Set("missed", 1, 0, tableTag(database, "other"))
+		r3.start(t)
+		eventually(t, "restarted peer not reconnected", func() bool {
+			ok, _ := peerState(addr3)
+			return ok
+		})
+		eventually(t, "caches not flushed on reconnection", func() bool { return !cached("missed") })
+	})
+}
@@ -0,0 +1,141 @@
+package kormongo
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+var (
+	// StaleWhileRevalidate serve entries expired for less than this duration while a single query refresh them in background, 0 disable it
+	// with it, the FlushCacheEvery sweep mark the entries as expired instead of removing them, writes still remove them
+	StaleWhileRevalidate time.Duration = 0
+	// RevalidateTimeout is the timeout of the background queries refreshing stale entries
+	RevalidateTimeout = 30 * time.Second
+	flightsMu         sync.Mutex
+	flights           = map[string]*flight{}
+)
+
+// flight is a fetch shared by the concurrent callers of the same query
+type flight struct {
+	done       chan struct{}
+	val        any
+	err        error
+	waiters    int
+	cancel     context.CancelFunc
+	background bool
+}
+
+// cachedQuery return the cached result of key, concurrent misses of the same key wait for a single fetch, whose result is stored using set
+// the boolean is true for a cache hit, stale entries are hits refreshed in background
+// gen is the cacheGeneration of the table, a query never wait for a fetch started before a write it follows
+// the shared fetch keep the deadline of the caller that started it, each caller stop waiting when its own ctx is done and the fetch is canceled once none wait
+func cachedQuery[V any](ctx context.Context, name string, store CacheStore, key string, gen uint64, refresh bool, fetch func(ctx context.Context) (V, error), set func(v V)) (V, bool, error) {
+	flightKey := name + ":" + strconv.FormatUint(gen, 10) + ":" + key
+	fetchAndSet := func(ctx context.Context) (any, error) {
+		res, err := fetch(ctx)
+		if err == nil {
+			set(res)
+		}
+		return res, err
+	}
+	if refresh {
+		res, err := fetch(ctx)
+		if err == nil {
+			set(res)
+		}
+		return res, false, err
+	}
+	if v, stale, ok := lookupCache(store, key); ok {
+		if res, ok := cacheValue[V](v); ok {
+			if stale {
+				joinFlight(ctx, flightKey, true, fetchAndSet)
+			}
+			return res, true, nil
+		}
+	}
+	f := joinFlight(ctx, flightKey, false, fetchAndSet)
+	select {
+	case <-f.done:
+		res, _ := f.val.(V)
+		return res, false, f.err
+	case <-ctx.Done():
+		f.leave(flightKey)
+		return *new(V), false, ctx.Err()
+	}
+}
+
+// joinFlight return the flight of key, starting fn in a new one if none is running
+// a background flight refresh a stale entry for nobody waiting, it run for at most RevalidateTimeout and is never canceled by its waiters
+func joinFlight(ctx context.Context, key string, background bool, fn func(ctx context.Context) (any, error)) *flight {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	if f, ok := flights[key]; ok {
+		if !background {
+			f.waiters++
+		}
+		return f
+	}
+	var fctx context.Context
+	var cancel context.CancelFunc
+	if background {
+		fctx, cancel = context.WithTimeout(detachedContext{ctx}, RevalidateTimeout)
+	} else if deadline, ok := ctx.Deadline(); ok {
+		fctx, cancel = context.WithDeadline(detachedContext{ctx}, deadline)
+	} else {
+		fctx, cancel = context.WithCancel(detachedContext{ctx})
+	}
+	f := &flight{done: make(chan struct{}), cancel: cancel, background: background}
+	if !background {
+		f.waiters = 1
+	}
+	flights[key] = f
+	go func() {
+		defer cancel()
+		f.val, f.err = fn(fctx)
+		flightsMu.Lock()
+		if flights[key] == f {
+			delete(flights, key)
+		}
+		flightsMu.Unlock()
+		close(f.done)
+	}()
+	return f
+}
+
+// leave is called by a caller that stopped waiting for f, the fetch is canceled when it was the last one
+func (f *flight) leave(key string) {
+	flightsMu.Lock()
+	defer flightsMu.Unlock()
+	f.waiters--
+	if f.waiters > 0 || f.background {
+		return
+	}
+	// the next callers start a new fetch instead of joining the canceled one
+	if flights[key] == f {
+		delete(flights, key)
+	}
+	f.cancel()
+}
+
+// detachedContext keep the values of its parent, ex: for the hooks and middlewares, but not its cancellation nor deadline
+type detachedContext struct {
+	parent context.Context
+}
+
+func (detachedContext) Deadline() (time.Time, bool) { return time.Time{}, false }
+func (detachedContext) Done() <-chan struct{}       { return nil }
+func (detachedContext) Err() error                  { return nil }
+func (c detachedContext) Value(key any) any         { return c.parent.Value(key) }
+
+// lookupCache return the entry of key, stale is true for an entry expired since less than StaleWhileRevalidate
+func lookupCache(store CacheStore, key string) (v any, stale bool, ok bool) {
+	if s, ok := store.(interface {
+		getStale(key string) (any, bool, bool)
+	}); ok {
+		return s.getStale(key)
+	}
+	v, ok = store.Get(key)
+	return v, false, ok
+}
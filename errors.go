@@ -0,0 +1,90 @@
+package kormongo
+
+import (
+	"errors"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+var (
+	// ErrNotFound is returned when no document or table matched
+	ErrNotFound = errors.New("nothing found")
+	// ErrDatabaseNotFound is returned when the database is not connected
+	ErrDatabaseNotFound = errors.New("database not found")
+	// ErrTableNotLinked is returned when a builder has no table, use korm.AutoMigrate, korm.Model or korm.Table before
+	ErrTableNotLinked = errors.New("table not linked, execute korm.AutoMigrate or give the table name to korm.Model")
+	// ErrDuplicateKey is returned when a write violate a unique index
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrValidation is returned when a write is rejected by the collection validator
+	ErrValidation = errors.New("document failed validation")
+	// ErrStaleObject is returned by Replace and Set when the version of a korm:"version" model changed since it was read
+	ErrStaleObject = errors.New("stale object: the document was modified since it was read")
+	// ErrVersionNotChecked is returned by Set on a korm:"version" model when Where does not include the version, use Replace or add the version to Where
+	ErrVersionNotChecked = errors.New("version not checked: Where must include the version of a versioned model, or use Replace")
+)
+
+// documentValidationFailure is the mongo error code of writes rejected by a validator
+const documentValidationFailure = 121
+
+// QueryError wrap errors returned by builders operations with the table, database, operation and filter
+// errors.Is(err, ErrNotFound), errors.Is(err, ErrDuplicateKey) and errors.Is(err, ErrValidation) classify the driver error
+type QueryError struct {
+	Op       string
+	Database string
+	Table    string
+	Filter   map[string]any
+	Err      error
+}
+
+func (e *QueryError) Error() string {
+	return fmt.Sprintf("%s %s.%s %v: %v", e.Op, e.Database, e.Table, e.Filter, e.Err)
+}
+
+func (e *QueryError) Unwrap() error {
+	return e.Err
+}
+
+// Is classify the wrapped driver error
+func (e *QueryError) Is(target error) bool {
+	switch target {
+	case ErrNotFound:
+		return errors.Is(e.Err, mongo.ErrNoDocuments) || isDriverNotFound(e.Err)
+	case ErrDuplicateKey:
+		return mongo.IsDuplicateKeyError(e.Err)
+	case ErrValidation:
+		var se mongo.ServerError
+		return errors.As(e.Err, &se) && se.HasErrorCode(documentValidationFailure)
+	}
+	return false
+}
+
+// isDriverNotFound match the not found errors created by kmongodriver
+func isDriverNotFound(err error) bool {
+	if err == nil {
+		return false
+	}
+	switch err.Error() {
+	case "no data found", "no row found":
+		return true
+	}
+	return false
+}
+
+// queryError wrap err in a QueryError, return nil if err is nil
+func queryError(op, database, table string, filter map[string]any, err error) error {
+	if err == nil {
+		return nil
+	}
+	var qe *QueryError
+	if errors.As(err, &qe) {
+		return err
+	}
+	return &QueryError{
+		Op:       op,
+		Database: database,
+		Table:    table,
+		Filter:   filter,
+		Err:      err,
+	}
+}
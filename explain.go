@@ -0,0 +1,250 @@
+package kormongo
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ExplainSummary is the parsed result of the explain command
+type ExplainSummary struct {
+	// WinningPlan stages of the winning plan from the root, ex: [FETCH IXSCAN]
+	WinningPlan []string
+	// Indexes used by the winning plan, empty for a collection scan
+	Indexes          []string
+	CollectionScan   bool
+	DocsExamined     int64
+	KeysExamined     int64
+	DocsReturned     int64
+	ExecutionTimeMs  int64
+	HasExecutionInfo bool
+}
+
+// String return a short description of the plan, ex: 'IXSCAN email_1' or 'COLLSCAN'
+func (e ExplainSummary) String() string {
+	if e.CollectionScan || len(e.Indexes) == 0 {
+		return strings.Join(e.WinningPlan, " > ")
+	}
+	return strings.Join(e.WinningPlan, " > ") + " " + strings.Join(e.Indexes, ",")
+}
+
+// explainFind run the explain command on a find using filter, projection, sort, limit and page as given to the builders
+func explainFind(ctx context.Context, db *mongo.Database, table string, filter map[string]any, projection, sort string, limit, page int64, verbosity string) (ExplainSummary, error) {
+	find := bson.D{{Key: "find", Value: table}}
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	find = append(find, bson.E{Key: "filter", Value: filter})
+	if proj := projectionDoc(projection); proj != nil {
+		find = append(find, bson.E{Key: "projection", Value: proj})
+	}
+	if s := sortDoc(sort); len(s) > 0 {
+		find = append(find, bson.E{Key: "sort", Value: s})
+	}
+	if limit > 0 {
+		find = append(find, bson.E{Key: "limit", Value: limit})
+		if page > 1 {
+			find = append(find, bson.E{Key: "skip", Value: limit * (page - 1)})
+		}
+	}
+	return explainCommand(ctx, db, find, verbosity)
+}
+
+// explainCount run the explain command on the aggregate counting the documents matching filter, as sent by Count
+func explainCount(ctx context.Context, db *mongo.Database, table string, filter map[string]any, verbosity string) (ExplainSummary, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	return explainCommand(ctx, db, bson.D{
+		{Key: "aggregate", Value: table},
+		{Key: "pipeline", Value: bson.A{
+			bson.D{{Key: "$match", Value: filter}},
+			bson.D{{Key: "$group", Value: bson.D{{Key: "_id", Value: 1}, {Key: "n", Value: bson.D{{Key: "$sum", Value: 1}}}}}},
+		}},
+		{Key: "cursor", Value: bson.D{}},
+	}, verbosity)
+}
+
+// explainMethod run explainFind or explainCount depending on method, 'find' if empty
+func explainMethod(ctx context.Context, db *mongo.Database, method, table string, filter map[string]any, projection, sort string, limit, page int64, verbosity string) (ExplainSummary, error) {
+	switch method {
+	case "", "find":
+		return explainFind(ctx, db, table, filter, projection, sort, limit, page, verbosity)
+	case "count":
+		return explainCount(ctx, db, table, filter, verbosity)
+	}
+	return ExplainSummary{}, fmt.Errorf("explain: unknown method %q, use 'find' or 'count'", method)
+}
+
+// explainCommand run explain on cmd, verbosity is 'queryPlanner', 'executionStats' or 'allPlansExecution'
+func explainCommand(ctx context.Context, db *mongo.Database, cmd bson.D, verbosity string) (ExplainSummary, error) {
+	if verbosity == "" {
+		verbosity = "queryPlanner"
+	}
+	res := bson.M{}
+	err := db.RunCommand(ctx, bson.D{
+		{Key: "explain", Value: cmd},
+		{Key: "verbosity", Value: verbosity},
+	}, options.RunCmd()).Decode(&res)
+	if err != nil {
+		return ExplainSummary{}, err
+	}
+	return parseExplain(res), nil
+}
+
+// Explain run the explain command on the find executed by All, or on the count of Count if method is 'count'
+// verbosity is 'queryPlanner' (default), 'executionStats' or 'allPlansExecution'
+//
+//	sum, _ := korm.Model[User]().Where("email", email).Explain("executionStats")
+//	if sum.CollectionScan { ... }
+//	sum, _ = korm.Model[User]().Where("is_admin", true).Explain("executionStats", "count")
+func (b *Builder[T]) Explain(verbosity string, method ...string) (sum ExplainSummary, err error) {
+	if b.database == "" {
+		b.database = databases[0].Name
+	}
+	if b.tableName == "" {
+		return sum, ErrTableNotLinked
+	}
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return sum, err
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "explain", Database: db.Name, Table: b.tableName, Filter: b.scope(b.whereFields())}, func(op *OpInfo) error {
+		m := ""
+		if len(method) > 0 {
+			m = method[0]
+		}
+		res, err := explainMethod(op.Ctx, db.MongoConn, m, b.tableName, op.Filter, b.selected, b.orderBys, int64(b.limit), int64(b.page), verbosity)
+		if err != nil {
+			return queryError("explain", db.Name, b.tableName, op.Filter, err)
+		}
+		sum = res
+		return nil
+	})
+	return sum, err
+}
+
+// Explain run the explain command on the find executed by All, or on the count of Count if method is 'count'
+// verbosity is 'queryPlanner' (default), 'executionStats' or 'allPlansExecution'
+func (b *BuilderM) Explain(verbosity string, method ...string) (sum ExplainSummary, err error) {
+	if b.tableName == "" {
+		return sum, ErrTableNotLinked
+	}
+	if b.database == "" {
+		b.database = databases[0].Name
+	}
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return sum, err
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "explain", Database: db.Name, Table: b.tableName, Filter: b.whereFields()}, func(op *OpInfo) error {
+		m := ""
+		if len(method) > 0 {
+			m = method[0]
+		}
+		res, err := explainMethod(op.Ctx, db.MongoConn, m, b.tableName, op.Filter, b.selected, b.orderBys, int64(b.limit), int64(b.page), verbosity)
+		if err != nil {
+			return queryError("explain", db.Name, b.tableName, op.Filter, err)
+		}
+		sum = res
+		return nil
+	})
+	return sum, err
+}
+
+func parseExplain(res bson.M) ExplainSummary {
+	sum := ExplainSummary{}
+	// the explain of an aggregate run by the classic engine put the plan of its query in the $cursor stage
+	if stages, ok := res["stages"].(bson.A); ok && len(stages) > 0 {
+		if st, ok := stages[0].(bson.M); ok {
+			if c, ok := st["$cursor"].(bson.M); ok {
+				res = c
+			}
+		}
+	}
+	if qp, ok := res["queryPlanner"].(bson.M); ok {
+		if wp, ok := qp["winningPlan"].(bson.M); ok {
+			// slot based engine wrap the plan in queryPlan
+			if p, ok := wp["queryPlan"].(bson.M); ok {
+				wp = p
+			}
+			walkPlan(wp, &sum)
+		}
+	}
+	if es, ok := res["executionStats"].(bson.M); ok {
+		sum.HasExecutionInfo = true
+		sum.DocsExamined = toInt64(es["totalDocsExamined"])
+		sum.KeysExamined = toInt64(es["totalKeysExamined"])
+		sum.DocsReturned = toInt64(es["nReturned"])
+		sum.ExecutionTimeMs = toInt64(es["executionTimeMillis"])
+	}
+	return sum
+}
+
+func walkPlan(stage bson.M, sum *ExplainSummary) {
+	name, _ := stage["stage"].(string)
+	if name != "" {
+		sum.WinningPlan = append(sum.WinningPlan, name)
+	}
+	if name == "COLLSCAN" {
+		sum.CollectionScan = true
+	}
+	if idx, ok := stage["indexName"].(string); ok && idx != "" {
+		sum.Indexes = append(sum.Indexes, idx)
+	}
+	if in, ok := stage["inputStage"].(bson.M); ok {
+		walkPlan(in, sum)
+	}
+	if ins, ok := stage["inputStages"].(bson.A); ok {
+		for _, in := range ins {
+			if m, ok := in.(bson.M); ok {
+				walkPlan(m, sum)
+			}
+		}
+	}
+}
+
+func toInt64(v any) int64 {
+	switch n := v.(type) {
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case float64:
+		return int64(n)
+	case int:
+		return int64(n)
+	}
+	return 0
+}
+
+// sortDoc convert '-created,+email' to a sort document
+func sortDoc(orderBy string) bson.D {
+	s := bson.D{}
+	orderBy = strings.TrimSpace(strings.ReplaceAll(orderBy, "ORDER BY", ""))
+	if orderBy == "" {
+		return s
+	}
+	for _, f := range strings.Split(orderBy, ",") {
+		f = strings.TrimSpace(f)
+		switch {
+		case strings.HasPrefix(f, "-"):
+			s = append(s, bson.E{Key: strings.TrimSpace(f[1:]), Value: -1})
+		case strings.HasPrefix(f, "+"):
+			s = append(s, bson.E{Key: strings.TrimSpace(f[1:]), Value: 1})
+		case f != "":
+			s = append(s, bson.E{Key: f, Value: 1})
+		}
+	}
+	return s
+}
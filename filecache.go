@@ -0,0 +1,217 @@
+package kormongo
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// fileCache is a CacheStore persisted in a directory, one file per entry, values are kept on disk and only the index in memory
+type fileCache struct {
+	dir   string
+	mu    sync.Mutex
+	seq   uint64
+	index map[string]fileCacheMeta
+	tags  map[string]map[string]struct{}
+}
+
+type fileCacheMeta struct {
+	seq     uint64 // change on every write of the entry
+	expires time.Time
+	tags    []string
+}
+
+type fileCacheEntry struct {
+	Key     string    `bson:"key"`
+	Value   []byte    `bson:"value"`
+	Expires time.Time `bson:"expires"`
+	Tags    []string  `bson:"tags"`
+}
+
+// NewFileCacheStore return a CacheStore persisted in dir, so the cache stay warm across restarts and deploys
+// writes done while the app was stopped do not invalidate it, prefer Cache(ttl) on the queries cached this way
+func NewFileCacheStore(dir string) (CacheStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	c := &fileCache{
+		dir:   dir,
+		index: map[string]fileCacheMeta{},
+		tags:  map[string]map[string]struct{}{},
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.cache"))
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range files {
+		ent, err := readFileCacheEntry(f)
+		if err != nil || (!ent.Expires.IsZero() && time.Now().After(ent.Expires)) {
+			os.Remove(f)
+			continue
+		}
+		c.add(ent.Key, fileCacheMeta{expires: ent.Expires, tags: ent.Tags})
+	}
+	return c, nil
+}
+
+func readFileCacheEntry(path string) (fileCacheEntry, error) {
+	ent := fileCacheEntry{}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ent, err
+	}
+	err = bson.Unmarshal(b, &ent)
+	return ent, err
+}
+
+func (c *fileCache) path(key string) string {
+	h := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(h[:])+".cache")
+}
+
+func (c *fileCache) add(key string, meta fileCacheMeta) {
+	c.seq++
+	meta.seq = c.seq
+	c.index[key] = meta
+	for _, t := range meta.tags {
+		if c.tags[t] == nil {
+			c.tags[t] = map[string]struct{}{}
+		}
+		c.tags[t][key] = struct{}{}
+	}
+}
+
+func (c *fileCache) remove(key string) {
+	meta, ok := c.index[key]
+	if !ok {
+		return
+	}
+	delete(c.index, key)
+	for _, t := range meta.tags {
+		delete(c.tags[t], key)
+		if len(c.tags[t]) == 0 {
+			delete(c.tags, t)
+		}
+	}
+	if err := os.Remove(c.path(key)); err != nil && !os.IsNotExist(err) {
+		logger.Error("file cache: unable to remove entry", "key", key, "error", err)
+	}
+}
+
+// Get read the entry outside the lock, a file replaced meanwhile is read whole since writes are renamed into place
+func (c *fileCache) Get(key string) (any, bool) {
+	c.mu.Lock()
+	meta, ok := c.index[key]
+	if ok && !meta.expires.IsZero() && time.Now().After(meta.expires) {
+		c.remove(key)
+		ok = false
+	}
+	c.mu.Unlock()
+	if !ok {
+		return nil, false
+	}
+	ent, err := readFileCacheEntry(c.path(key))
+	if err != nil || ent.Key != key {
+		c.mu.Lock()
+		// the entry may have been written again since it was read
+		if cur, ok := c.index[key]; ok && cur.seq == meta.seq {
+			c.remove(key)
+		}
+		c.mu.Unlock()
+		return nil, false
+	}
+	return ent.Value, true
+}
+
+func (c *fileCache) Set(key string, value any, ttl time.Duration, tags ...string) {
+	v, err := encodeCacheValue(value)
+	if err != nil {
+		logger.Error("file cache: unable to encode value", "key", key, "error", err)
+		return
+	}
+	ent := fileCacheEntry{Key: key, Value: v, Tags: tags}
+	if ttl > 0 {
+		ent.Expires = time.Now().Add(ttl)
+	}
+	b, err := bson.Marshal(ent)
+	if err != nil {
+		logger.Error("file cache: unable to encode entry", "key", key, "error", err)
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+	path := c.path(key)
+	if err := os.WriteFile(path+".tmp", b, 0o644); err != nil {
+		logger.Error("file cache: unable to write entry", "key", key, "error", err)
+		return
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		logger.Error("file cache: unable to write entry", "key", key, "error", err)
+		return
+	}
+	c.add(key, fileCacheMeta{expires: ent.Expires, tags: tags})
+}
+
+func (c *fileCache) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.remove(key)
+}
+
+func (c *fileCache) DeleteByTag(tag string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.tags[tag] {
+		c.remove(key)
+	}
+}
+
+func (c *fileCache) Flush() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key := range c.index {
+		c.remove(key)
+	}
+	// entries of files not indexed, ex: written by an older process
+	files, _ := filepath.Glob(filepath.Join(c.dir, "*.cache*"))
+	for _, f := range files {
+		if strings.HasSuffix(f, ".cache") || strings.HasSuffix(f, ".cache.tmp") {
+			os.Remove(f)
+		}
+	}
+}
+
+// keys return the keys of the entries tagged with tag, all of them if tag is empty
+func (c *fileCache) keys(tag string) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := []string{}
+	if tag == "" {
+		for key := range c.index {
+			res = append(res, key)
+		}
+		return res
+	}
+	for key := range c.tags[tag] {
+		res = append(res, key)
+	}
+	return res
+}
+
+// sweep remove the expired entries and those without their own ttl
+func (c *fileCache) sweep() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, meta := range c.index {
+		if meta.expires.IsZero() || time.Now().After(meta.expires) {
+			c.remove(key)
+		}
+	}
+}
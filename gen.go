@@ -0,0 +1,278 @@
+package kormongo
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// genStruct accumulate the fields found in sampled documents
+type genStruct struct {
+	docs   int
+	order  []string
+	fields map[string]*genField
+}
+
+// genField accumulate the values found for a single key
+type genField struct {
+	count int
+	nulls int
+	types map[string]int
+	obj   *genStruct
+	elem  *genField
+}
+
+var commonInitialisms = map[string]string{
+	"id": "ID", "url": "URL", "uri": "URI", "api": "API", "ip": "IP", "json": "JSON",
+	"html": "HTML", "http": "HTTP", "uuid": "UUID", "sql": "SQL", "ttl": "TTL",
+}
+
+// GenerateModels sample the given tables, or all tables if none given, and write a go file with the inferred struct for each one in dir
+// absent or null fields are generated as pointers with omitempty, nested documents as their own struct, arrays as slices
+func GenerateModels(dir, pkgName string, tables []string, dbName ...string) error {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := GetMemoryDatabase(name)
+	if err != nil {
+		return err
+	}
+	if len(tables) == 0 {
+		for _, t := range GetAllTables(db.Name) {
+			if !strings.HasPrefix(t, "_") && !strings.HasPrefix(t, "system.") {
+				tables = append(tables, t)
+			}
+		}
+		sort.Strings(tables)
+	}
+	if pkgName == "" {
+		pkgName = filepath.Base(dir)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	ctx := context.Background()
+	for _, table := range tables {
+		cur, err := db.MongoConn.Collection(table).Aggregate(ctx, bson.A{
+			bson.M{"$sample": bson.M{"size": SampleSize}},
+		})
+		if err != nil {
+			return err
+		}
+		docs := []bson.D{}
+		if err := cur.All(ctx, &docs); err != nil {
+			return err
+		}
+		gs := &genStruct{fields: map[string]*genField{}}
+		for _, d := range docs {
+			gs.add(d)
+		}
+		src, err := gs.source(pkgName, goName(table))
+		if err != nil {
+			return fmt.Errorf("%s: %w", table, err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, table+".go"), src, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (gs *genStruct) add(d bson.D) {
+	gs.docs++
+	for _, e := range d {
+		f, ok := gs.fields[e.Key]
+		if !ok {
+			f = &genField{types: map[string]int{}}
+			gs.fields[e.Key] = f
+			gs.order = append(gs.order, e.Key)
+		}
+		f.count++
+		f.add(e.Value)
+	}
+}
+
+func (f *genField) add(v any) {
+	switch vv := v.(type) {
+	case nil:
+		f.nulls++
+		return
+	case bson.D:
+		if f.obj == nil {
+			f.obj = &genStruct{fields: map[string]*genField{}}
+		}
+		f.obj.add(vv)
+		f.types["object"]++
+		return
+	case bson.A:
+		if f.elem == nil {
+			f.elem = &genField{types: map[string]int{}}
+		}
+		for _, e := range vv {
+			f.elem.count++
+			f.elem.add(e)
+		}
+		f.types["array"]++
+		return
+	}
+	f.types[genScalarType(v)]++
+}
+
+func genScalarType(v any) string {
+	switch v.(type) {
+	case string:
+		return "string"
+	case bool:
+		return "bool"
+	case int32:
+		return "int"
+	case int64:
+		return "int64"
+	case float64:
+		return "float64"
+	case primitive.DateTime:
+		return "time.Time"
+	case primitive.ObjectID:
+		return "primitive.ObjectID"
+	case primitive.Decimal128:
+		return "primitive.Decimal128"
+	case primitive.Binary:
+		return "[]byte"
+	case primitive.Timestamp:
+		return "primitive.Timestamp"
+	default:
+		return "any"
+	}
+}
+
+// goType return the go type of the field, nested structs are appended to nested using parent as prefix of their names
+func (f *genField) goType(parent string, nested *[]genNamed) string {
+	if len(f.types) == 0 {
+		return "any"
+	}
+	types := make([]string, 0, len(f.types))
+	for t := range f.types {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	t := types[0]
+	if len(types) > 1 {
+		t = mergeNumeric(types)
+	}
+	switch t {
+	case "object":
+		*nested = append(*nested, genNamed{name: parent, s: f.obj})
+		return parent
+	case "array":
+		if f.elem == nil || f.elem.count == f.elem.nulls {
+			return "[]any"
+		}
+		return "[]" + f.elem.goType(parent+"Item", nested)
+	}
+	return t
+}
+
+// mergeNumeric return the widest numeric type if all types are numbers, otherwise any
+func mergeNumeric(types []string) string {
+	res := ""
+	for _, t := range types {
+		switch {
+		case t == "float64" || (t == "int64" && res != "float64") || (t == "int" && res == ""):
+			res = t
+		case t != "int" && t != "int64":
+			return "any"
+		}
+	}
+	return res
+}
+
+type genNamed struct {
+	name string
+	s    *genStruct
+}
+
+func (gs *genStruct) source(pkgName, typeName string) ([]byte, error) {
+	var body bytes.Buffer
+	queue := []genNamed{{name: typeName, s: gs}}
+	for i := 0; i < len(queue); i++ {
+		cur := queue[i]
+		fmt.Fprintf(&body, "\ntype %s struct {\n", cur.name)
+		used := map[string]bool{}
+		for _, key := range cur.s.order {
+			f := cur.s.fields[key]
+			fieldName := goName(key)
+			for n := 2; used[fieldName]; n++ {
+				fieldName = fmt.Sprintf("%s%d", goName(key), n)
+			}
+			used[fieldName] = true
+			nested := []genNamed{}
+			t := f.goType(cur.name+fieldName, &nested)
+			queue = append(queue, nested...)
+			optional := f.count < cur.s.docs || f.nulls > 0
+			if optional && t != "any" && !strings.HasPrefix(t, "[]") {
+				t = "*" + t
+			}
+			bsonTag := key
+			if optional || key == "_id" {
+				bsonTag += ",omitempty"
+			}
+			jsonTag := key
+			if optional {
+				jsonTag += ",omitempty"
+			}
+			fmt.Fprintf(&body, "\t%s %s `bson:%q json:%q`\n", fieldName, t, bsonTag, jsonTag)
+		}
+		body.WriteString("}\n")
+	}
+
+	var src bytes.Buffer
+	src.WriteString("// Code generated by kormongo gen. DO NOT EDIT.\n\n")
+	fmt.Fprintf(&src, "package %s\n", pkgName)
+	imports := []string{}
+	if bytes.Contains(body.Bytes(), []byte("time.Time")) {
+		imports = append(imports, `"time"`)
+	}
+	if bytes.Contains(body.Bytes(), []byte("primitive.")) {
+		if len(imports) > 0 {
+			imports = append(imports, "")
+		}
+		imports = append(imports, `"go.mongodb.org/mongo-driver/bson/primitive"`)
+	}
+	if len(imports) > 0 {
+		fmt.Fprintf(&src, "\nimport (\n\t%s\n)\n", strings.Join(imports, "\n\t"))
+	}
+	src.Write(body.Bytes())
+	return format.Source(src.Bytes())
+}
+
+// goName return an exported go identifier from a collection or a field name: 'user_id' -> 'UserID'
+func goName(s string) string {
+	parts := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	var b strings.Builder
+	for _, p := range parts {
+		if v, ok := commonInitialisms[strings.ToLower(p)]; ok {
+			b.WriteString(v)
+			continue
+		}
+		r := []rune(p)
+		r[0] = unicode.ToUpper(r[0])
+		b.WriteString(string(r))
+	}
+	name := b.String()
+	if name == "" || !unicode.IsLetter([]rune(name)[0]) {
+		name = "F" + name
+	}
+	return name
+}
@@ -2,25 +2,11 @@ package kormongo
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"time"
-
-	"github.com/kamalshkeir/klog"
 )
 
-type dbCache struct {
-	limit      int
-	page       int
-	database   string
-	table      string
-	selected   string
-	orderBys   string
-	whereQuery string
-	query      string
-	offset     string
-	statement  string
-	args       string
-}
-
 func getTableName[T comparable]() string {
 	if v, ok := mModelTablename[*new(T)]; ok {
 		return v
@@ -33,7 +19,7 @@ func RunEvery(t time.Duration, function any) {
 	//Usage : go RunEvery(2 * time.Second,func(){})
 	fn, ok := function.(func())
 	if !ok {
-		fmt.Println("ERROR : fn is not a function")
+		logger.Error("RunEvery: fn is not a function")
 		return
 	}
 
@@ -45,58 +31,153 @@ func RunEvery(t time.Duration, function any) {
 	}
 }
 
+// setFields return the update of Set: 'email, is_admin' or 'email = ?, is_admin = ?' take the values from args in order, 'email=value' set the string value
+func setFields(fieldsCommaSeparated string, args ...any) (map[string]any, error) {
+	row := map[string]any{}
+	i := 0
+	for _, s := range strings.Split(fieldsCommaSeparated, ",") {
+		field, value, literal := strings.Cut(s, "=")
+		field, value = strings.TrimSpace(field), strings.TrimSpace(value)
+		if field == "" {
+			return nil, fmt.Errorf("set: empty field in %q", fieldsCommaSeparated)
+		}
+		if literal && value != "?" {
+			row[field] = value
+			continue
+		}
+		if i >= len(args) {
+			return nil, fmt.Errorf("set: no value given for %s", field)
+		}
+		row[field] = args[i]
+		i++
+	}
+	if i != len(args) {
+		return nil, fmt.Errorf("set: %d values given for %d fields", len(args), i)
+	}
+	return row, nil
+}
+
 func handleCache(data map[string]any) {
+	if data["type"] == "ping" {
+		// a ClusterCache peer check this replica is alive, it can be itself
+		publishCache(map[string]any{
+			"type": "pong",
+			"from": cacheOrigin,
+			"to":   data["from"],
+			"peer": data["peer"],
+		})
+		return
+	}
+	if from, _ := data["from"].(string); from == cacheOrigin {
+		return
+	}
 	switch data["type"] {
-	case "create","delete","update":
-		if v,ok := data["table"];ok {
-			dbName := data["database"].(string)
-			go func() {
-				cachesAllM.Range(func(key dbCache, value []map[string]any) {
-					if key.table == v && key.database == dbName {
-						go cachesAllM.Delete(key)
-					}
-				})
-				cachesAllS.Range(func(key dbCache, value any) {
-					if key.table == v && key.database == dbName {
-						go cachesAllS.Delete(key)
+	case "create", "delete", "update":
+		if v, ok := data["table"].(string); ok {
+			dbName, _ := data["database"].(string)
+			tags := []string{}
+			switch tt := data["tags"].(type) {
+			case []string:
+				tags = tt
+			case []any:
+				for _, t := range tt {
+					if s, ok := t.(string); ok {
+						tags = append(tags, s)
 					}
-				})
-				cachesOneM.Range(func(key dbCache, value map[string]any) {
-					if key.table == v && key.database == dbName {
-						go cachesOneM.Delete(key)
+				}
+			}
+			if len(tags) == 0 {
+				tags = []string{tableTag(dbName, v)}
+			}
+			// the shared stores of the writer already sent the eviction to their replicas
+			evictTable(dbName, v, func() {
+				for _, c := range localQueryCaches() {
+					for _, t := range tags {
+						c.DeleteByTag(t)
 					}
-				})
-				cachesOneS.Range(func(key dbCache, value any) {
-					if key.table == v && key.database == dbName {
-						go cachesOneS.Delete(key)
-					}
-				})	
-			}()			
+				}
+			})
 		} else {
-			go func() {
-				cachesAllM.Flush()
-				cachesAllS.Flush()
-				cachesOneM.Flush()
-				cachesOneS.Flush()
-			}()
+			flushLocalQueryCaches()
 		}
-	case "drop":
-		go func() {
-			cacheGetAllTables.Flush()
-			cachesAllM.Flush()
-			cachesAllS.Flush()
-			cachesOneM.Flush()
-			cachesOneS.Flush()
-		}()
-	case "clean":
-		go func() {
-			cacheGetAllTables.Flush()
-			cachesAllM.Flush()
-			cachesAllS.Flush()
-			cachesOneM.Flush()
-			cachesOneS.Flush()
-		}()
+	case "drop", "clean":
+		cacheGetAllTables.Flush()
+		flushLocalQueryCaches()
+	case "sweep":
+		cacheGetAllTables.Flush()
+		sweepQueryCaches()
 	default:
-		klog.Printf("CACHE DB: default case triggered %v \n", data)
+		logger.Warn("cache: unknown message", "data", data)
+	}
+}
+
+// publishCache publish a cache event for the other processes, through the server given to WithBus to reach its websocket clients
+func publishCache(data map[string]any) {
+	cacheBusMu.RLock()
+	defer cacheBusMu.RUnlock()
+	if cacheServer != nil {
+		cacheServer.Publish(CACHE_TOPIC, data)
+	} else if cachebus != nil {
+		cachebus.Publish(CACHE_TOPIC, data)
+	}
+}
+
+// queryCaches return the stores of All and One of both builders, a store shared by them using SetCacheStore is returned once
+func queryCaches() []CacheStore {
+	res := []CacheStore{}
+	for _, c := range []CacheStore{cachesAllM, cachesAllS, cachesOneM, cachesOneS} {
+		if n, ok := c.(namedStore); ok {
+			c = n.store
+		}
+		found := false
+		if reflect.TypeOf(c).Comparable() {
+			for _, r := range res {
+				if r == c {
+					found = true
+				}
+			}
+		}
+		if !found {
+			res = append(res, c)
+		}
+	}
+	return res
+}
+
+// flushQueryCaches flush the queries caches and reject the fills of all the queries in flight
+func flushQueryCaches() {
+	flushAll(func() {
+		for _, c := range queryCaches() {
+			c.Flush()
+		}
+	})
+}
+
+// localQueryCaches return queryCaches with the stores shared between replicas replaced by their entries in this process
+func localQueryCaches() []CacheStore {
+	res := queryCaches()
+	for i, c := range res {
+		if l, ok := c.(interface{ localStore() CacheStore }); ok {
+			res[i] = l.localStore()
+		}
+	}
+	return res
+}
+
+// flushLocalQueryCaches is flushQueryCaches without sending the flush to the other replicas of the shared stores
+func flushLocalQueryCaches() {
+	flushAll(func() {
+		for _, c := range localQueryCaches() {
+			c.Flush()
+		}
+	})
+}
+
+// sweepQueryCaches remove the expired entries and those cached without Cache(ttl), done every FlushCacheEvery
+func sweepQueryCaches() {
+	for _, c := range queryCaches() {
+		if sw, ok := c.(interface{ sweep() }); ok {
+			sw.sweep()
+		}
 	}
 }
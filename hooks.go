@@ -0,0 +1,71 @@
+package kormongo
+
+import "context"
+
+// BeforeInserter is implemented by models that need to run code before being inserted, returning an error abort the insert
+type BeforeInserter interface {
+	BeforeInsert(ctx context.Context) error
+}
+
+// AfterInserter is implemented by models that need to run code after being inserted
+type AfterInserter interface {
+	AfterInsert(ctx context.Context) error
+}
+
+// BeforeUpdater is implemented by models that need to run code before Set, returning an error abort the update
+type BeforeUpdater interface {
+	BeforeUpdate(ctx context.Context) error
+}
+
+// AfterUpdater is implemented by models that need to run code after Set
+type AfterUpdater interface {
+	AfterUpdate(ctx context.Context) error
+}
+
+// BeforeDeleter is implemented by models that need to run code before Delete, returning an error abort the delete
+type BeforeDeleter interface {
+	BeforeDelete(ctx context.Context) error
+}
+
+// AfterDeleter is implemented by models that need to run code after Delete
+type AfterDeleter interface {
+	AfterDelete(ctx context.Context) error
+}
+
+// AfterFinder is implemented by models that need to run code on documents loaded by All and One, cached results are not passed again
+type AfterFinder interface {
+	AfterFind(ctx context.Context) error
+}
+
+// HookContext describe the operation running a hook, Set and Delete have no document, hooks are called on a zero model and can use it to read the filter or modify Update
+type HookContext struct {
+	Database string
+	Table    string
+	Filter   map[string]any
+	Update   map[string]any
+}
+
+type hookCtxKey struct{}
+
+// GetHookContext return the HookContext from the ctx given to a hook
+func GetHookContext(ctx context.Context) (*HookContext, bool) {
+	hc, ok := ctx.Value(hookCtxKey{}).(*HookContext)
+	return hc, ok
+}
+
+func withHookContext(ctx context.Context, hc *HookContext) context.Context {
+	return context.WithValue(ctx, hookCtxKey{}, hc)
+}
+
+// afterFind run AfterFind on each model
+func afterFind[T comparable](ctx context.Context, models []T) error {
+	if _, ok := any(new(T)).(AfterFinder); !ok {
+		return nil
+	}
+	for i := range models {
+		if err := any(&models[i]).(AfterFinder).AfterFind(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,384 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kamalshkeir/ksbus"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// tags of the cached entries, all prefixed by the table tag:
+//
+//	#id:<id>       the result contain the document id
+//	#noid          the result contain documents without _id, evicted by any update or delete
+//	#field:<name>  the filter or the sort use the field, evicted when it's updated
+//	#eq:<name>=<v> the filter require name equal v, evicted when a matching document is inserted
+//	#all           the filter has no equality condition, evicted by any insert
+//	#paged         the result is limited or paginated, evicted by any delete
+//	#trashed       the result contain only soft deleted documents, evicted by any soft delete
+
+func idTag(database, table string, id any) string {
+	return tableTag(database, table) + "#id:" + eqValue(id)
+}
+
+func fieldTag(database, table, field string) string {
+	field, _, _ = strings.Cut(strings.TrimSpace(field), ".")
+	return tableTag(database, table) + "#field:" + field
+}
+
+func eqTag(database, table, field string, v any) string {
+	return tableTag(database, table) + "#eq:" + field + "=" + eqValue(v)
+}
+
+func flagTag(database, table, flag string) string {
+	return tableTag(database, table) + "#" + flag
+}
+
+// eqValue return the same string for equal values of different types, ex: int32(5) and 5.0, time.Time and primitive.DateTime
+func eqValue(v any) string {
+	rv := reflect.ValueOf(v)
+	for rv.IsValid() && rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return "<nil>"
+		}
+		rv = rv.Elem()
+	}
+	if !rv.IsValid() {
+		return "<nil>"
+	}
+	switch vv := rv.Interface().(type) {
+	case time.Time:
+		return vv.UTC().Truncate(time.Millisecond).Format(time.RFC3339Nano)
+	case primitive.DateTime:
+		return vv.Time().UTC().Format(time.RFC3339Nano)
+	case primitive.ObjectID:
+		return vv.Hex()
+	}
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.FormatFloat(float64(rv.Int()), 'g', -1, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.FormatFloat(float64(rv.Uint()), 'g', -1, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.FormatFloat(rv.Float(), 'g', -1, 64)
+	}
+	return fmt.Sprint(rv.Interface())
+}
+
+// isScalar report if a filter value is compared by equality, operators and arrays are not
+func isScalar(v any) bool {
+	if v == nil {
+		return false
+	}
+	switch reflect.ValueOf(v).Kind() {
+	case reflect.Map, reflect.Slice:
+		return false
+	}
+	return true
+}
+
+// cacheTags return the tags of a cached result, so a write only evict the entries it can change
+func cacheTags(database, table string, filter map[string]any, sort string, paged bool, result any) []string {
+	tags := []string{tableTag(database, table)}
+	eq := false
+	for k, v := range filter {
+		tags = append(tags, fieldTag(database, table, k))
+		if isScalar(v) && !strings.Contains(k, ".") {
+			tags = append(tags, eqTag(database, table, k, v))
+			eq = true
+		}
+	}
+	if !eq {
+		tags = append(tags, flagTag(database, table, "all"))
+	}
+	for _, s := range sortDoc(sort) {
+		tags = append(tags, fieldTag(database, table, s.Key))
+	}
+	if paged {
+		tags = append(tags, flagTag(database, table, "paged"))
+	}
+	if ids, ok := resultIDs(result); ok {
+		for _, id := range ids {
+			tags = append(tags, idTag(database, table, id))
+		}
+	} else {
+		tags = append(tags, flagTag(database, table, "noid"))
+	}
+	return tags
+}
+
+// resultIDs return the _id of the documents of a result, false if one of them has no _id
+func resultIDs(result any) ([]any, bool) {
+	rv := reflect.ValueOf(result)
+	if rv.Kind() == reflect.Slice {
+		ids := make([]any, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			id, ok := documentFields(rv.Index(i).Interface())["_id"]
+			if !ok || id == nil {
+				return nil, false
+			}
+			ids = append(ids, id)
+		}
+		return ids, true
+	}
+	id, ok := documentFields(result)["_id"]
+	if !ok || id == nil {
+		return nil, false
+	}
+	return []any{id}, true
+}
+
+// documentFields return the top level fields of a map or a model
+func documentFields(doc any) map[string]any {
+	switch d := doc.(type) {
+	case map[string]any:
+		return d
+	case bson.M:
+		return d
+	}
+	rv := reflect.ValueOf(doc)
+	for rv.Kind() == reflect.Pointer {
+		if rv.IsNil() {
+			return map[string]any{}
+		}
+		rv = rv.Elem()
+	}
+	res := map[string]any{}
+	if rv.Kind() != reflect.Struct {
+		return res
+	}
+	for _, f := range modelFields(rv.Type()) {
+		if fv, err := rv.FieldByIndexErr(f.Index); err == nil {
+			if f.Column == "_id" && fv.IsZero() {
+				continue
+			}
+			res[f.Column] = fv.Interface()
+		}
+	}
+	return res
+}
+
+// insertTags return the tags of the entries the insertion of doc can change: those whose equality conditions doc match
+func insertTags(database, table string, doc any) []string {
+	tags := []string{flagTag(database, table, "all")}
+	for k, v := range documentFields(doc) {
+		tags = append(tags, eqTag(database, table, k, v))
+		rv := reflect.ValueOf(v)
+		if rv.Kind() == reflect.Slice && rv.Type().Elem().Kind() != reflect.Uint8 {
+			for i := 0; i < rv.Len(); i++ {
+				tags = append(tags, eqTag(database, table, k, rv.Index(i).Interface()))
+			}
+		}
+	}
+	return tags
+}
+
+// writeTags return the tags of the entries an update of fields or a delete of the documents ids can change, the whole table if ids are unknown
+func writeTags(database, table string, ids []any, fields []string, deleted bool) []string {
+	if len(ids) == 0 {
+		return []string{tableTag(database, table)}
+	}
+	tags := []string{flagTag(database, table, "noid")}
+	for _, id := range ids {
+		tags = append(tags, idTag(database, table, id))
+	}
+	for _, f := range fields {
+		tags = append(tags, fieldTag(database, table, f))
+	}
+	if deleted {
+		tags = append(tags, flagTag(database, table, "paged"))
+	}
+	return tags
+}
+
+// updateOne apply update to the first document matching filter and return its _id, nil if none matched
+// the _id come from the write itself so the evicted entries are those of the document actually changed
+func updateOne(ctx context.Context, db *mongo.Database, table string, filter map[string]any, update any) (any, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	res := bson.M{}
+	err := db.Collection(table).FindOneAndUpdate(ctx, filter, update, options.FindOneAndUpdate().SetProjection(bson.M{"_id": 1})).Decode(&res)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return res["_id"], err
+}
+
+// replaceOne replace the first document matching filter and return its _id, nil if none matched
+func replaceOne(ctx context.Context, db *mongo.Database, table string, filter map[string]any, replacement any) (any, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	res := bson.M{}
+	err := db.Collection(table).FindOneAndReplace(ctx, filter, replacement, options.FindOneAndReplace().SetProjection(bson.M{"_id": 1})).Decode(&res)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, nil
+	}
+	return res["_id"], err
+}
+
+// deleteOne delete the first document matching filter and return its _id, mongo.ErrNoDocuments if none matched
+func deleteOne(ctx context.Context, db *mongo.Database, table string, filter map[string]any) (any, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	res := bson.M{}
+	err := db.Collection(table).FindOneAndDelete(ctx, filter, options.FindOneAndDelete().SetProjection(bson.M{"_id": 1})).Decode(&res)
+	return res["_id"], err
+}
+
+// invalidateWrite evict the entries a write of the document id can change: nothing if it matched no document, the whole table if its outcome is unknown
+func invalidateWrite(kind, database, table string, id any, err error, fields []string, deleted bool, extra ...string) {
+	if !writeApplied(err) || (err == nil && id == nil) {
+		return
+	}
+	var ids []any
+	if err == nil {
+		ids = []any{id}
+	}
+	invalidate(kind, database, table, append(writeTags(database, table, ids, fields, deleted), extra...))
+}
+
+// generations of the cached results, a fill is rejected if a write evicted the table since its query started
+var (
+	cacheOrigin = ksbus.GenerateRandomString(12)
+	cacheGenMu  sync.RWMutex // locked for writing by flushes
+	cacheGen    uint64
+	flushedAt   time.Time
+	tableGensMu sync.Mutex
+	tableGens   = map[string]*tableGen{}
+)
+
+// maxClockSkew is the clock difference tolerated between replicas when an entry filled by another one is received
+const maxClockSkew = time.Second
+
+type tableGen struct {
+	mu        sync.Mutex
+	gen       uint64
+	evictedAt time.Time
+}
+
+func getTableGen(database, table string) *tableGen {
+	tableGensMu.Lock()
+	defer tableGensMu.Unlock()
+	tg, ok := tableGens[tableTag(database, table)]
+	if !ok {
+		tg = &tableGen{}
+		tableGens[tableTag(database, table)] = tg
+	}
+	return tg
+}
+
+// cacheGeneration return the generation of the cached results of the table, taken before querying it
+func cacheGeneration(database, table string) uint64 {
+	cacheGenMu.RLock()
+	defer cacheGenMu.RUnlock()
+	tg := getTableGen(database, table)
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	return cacheGen + tg.gen
+}
+
+// fillCache call set if the table was not evicted since gen was taken, so a query racing a write cannot cache what it read before it
+// set run under the table lock: either the entry is stored before the eviction and removed by it, or it is not stored
+func fillCache(database, table string, gen uint64, set func()) bool {
+	cacheGenMu.RLock()
+	defer cacheGenMu.RUnlock()
+	tg := getTableGen(database, table)
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	if cacheGen+tg.gen != gen {
+		return false
+	}
+	set()
+	return true
+}
+
+// evictTags remove the cached entries tagged with tags and reject the fills of the queries in flight on the table
+func evictTags(database, table string, tags []string) {
+	if len(tags) == 0 {
+		tags = []string{tableTag(database, table)}
+	}
+	evictTable(database, table, func() {
+		for _, c := range queryCaches() {
+			for _, t := range tags {
+				c.DeleteByTag(t)
+			}
+		}
+	})
+}
+
+// evictTable run evict under the table lock, rejecting the fills of the queries in flight and the entries filled before by other replicas
+func evictTable(database, table string, evict func()) {
+	cacheGenMu.RLock()
+	defer cacheGenMu.RUnlock()
+	tg := getTableGen(database, table)
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	tg.gen++
+	tg.evictedAt = time.Now()
+	evict()
+}
+
+// flushAll run flush rejecting the fills of all the queries in flight and the entries filled before by other replicas
+func flushAll(flush func()) {
+	cacheGenMu.Lock()
+	defer cacheGenMu.Unlock()
+	cacheGen++
+	flushedAt = time.Now()
+	flush()
+}
+
+// fillRemote call set if the table was not evicted since started, the time the query of an entry received from another replica started
+// the entry was read before an eviction processed here, it is either stale or evicted with the next message of the writer
+func fillRemote(database, table string, started time.Time, set func()) bool {
+	cacheGenMu.RLock()
+	defer cacheGenMu.RUnlock()
+	tg := getTableGen(database, table)
+	tg.mu.Lock()
+	defer tg.mu.Unlock()
+	limit := started.Add(-maxClockSkew)
+	if tg.evictedAt.After(limit) || flushedAt.After(limit) {
+		return false
+	}
+	set()
+	return true
+}
+
+// writeApplied report if a write returning err may have changed documents, only errors rejecting the whole write are excluded
+func writeApplied(err error) bool {
+	return err == nil || !(errors.Is(err, ErrNotFound) || errors.Is(err, ErrDuplicateKey) || errors.Is(err, ErrValidation) || errors.Is(err, ErrStaleObject))
+}
+
+// invalidate evict the cached entries tagged with tags, the whole table if tags is empty, called once the write is done
+// the eviction is synchronous so the next read see the write, then it's published for the other processes
+func invalidate(kind, database, table string, tags []string) {
+	if !useCache {
+		return
+	}
+	if kind == "drop" {
+		cacheGetAllTables.Flush()
+		flushQueryCaches()
+	} else {
+		evictTags(database, table, tags)
+	}
+	go publishCache(map[string]any{
+		"type":     kind,
+		"table":    table,
+		"database": database,
+		"tags":     tags,
+		"from":     cacheOrigin,
+	})
+}
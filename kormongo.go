@@ -1,12 +1,12 @@
 package kormongo
 
 import (
-	"errors"
+	"fmt"
 	"net/http"
 	"os"
+	"sync"
 	"time"
 
-	"github.com/kamalshkeir/klog"
 	"github.com/kamalshkeir/kmap"
 	"github.com/kamalshkeir/kmongodriver"
 	"github.com/kamalshkeir/kmux/ws"
@@ -17,19 +17,27 @@ import (
 var (
 	// Debug when true show extra useful logs for queries executed for migrations and queries statements
 	Debug = false
-	// FlushCacheEvery execute korm.FlushCache() every 30 min by default, you should not worry about it, but useful that you can change it
+	// FlushCacheEvery remove every 30 min by default the cached queries without their own Cache(ttl) and the expired ones, you should not worry about it, but useful that you can change it
 	FlushCacheEvery = 30 * time.Minute
 	// DefaultDB keep tracking of the first database connected
-	DefaultDB         = ""
+	DefaultDB = ""
+	// ValidationLevel of the $jsonSchema validator applied by AutoMigrate when it create a collection: 'off', 'moderate' or 'strict', 'off' by default disable the generated validator
+	ValidationLevel = "off"
+	// ValidationAction of the $jsonSchema validator applied by AutoMigrate: 'error' reject invalid documents, 'warn' only log them in mongo
+	ValidationAction = "error"
+	// UpdateValidators replace with collMod the validator of the existing collections on AutoMigrate, false by default to keep the validators installed by hand, it require the collMod privilege
+	UpdateValidators  = false
 	useCache          = true
 	databases         = []DatabaseEntity{}
 	mModelTablename   = map[any]string{}
 	cacheGetAllTables = kmap.New[string, []string](false)
-	cachesOneM        = kmap.New[dbCache, map[string]any](false)
-	cachesAllM        = kmap.New[dbCache, []map[string]any](false)
+	cachesOneM        = CacheStore(newLRU("one_m"))
+	cachesAllM        = CacheStore(newLRU("all_m"))
 
-	onceDone = false
-	cachebus *ksbus.Bus
+	onceDone    = false
+	cachebus    *ksbus.Bus
+	cacheServer *ksbus.Server
+	cacheBusMu  sync.RWMutex // WithBus can be called while writes publish their events
 )
 
 const (
@@ -61,7 +69,9 @@ func New(dbName string,dbDSN ...string) error {
 	}
 	if !dbFound {
 		mc, err := kmongodriver.NewMongoFromDSN(dbName, dbDSN...)
-		if !klog.CheckError(err) {
+		if err != nil {
+			logger.Error("unable to connect", "database", dbName, "error", err)
+		} else {
 			databases = append(databases, DatabaseEntity{
 				Name:      dbName,
 				MongoConn: mc,
@@ -77,7 +87,7 @@ func New(dbName string,dbDSN ...string) error {
 			})
 			go RunEvery(FlushCacheEvery, func() {
 				go cachebus.Publish(CACHE_TOPIC, map[string]any{
-					"type": "clean",
+					"type": "sweep",
 				})
 			})
 		}
@@ -107,7 +117,7 @@ func NewFromConnection(dbName string,dbConn *mongo.Database) error {
 			})
 			go RunEvery(FlushCacheEvery, func() {
 				go cachebus.Publish(CACHE_TOPIC, map[string]any{
-					"type": "clean",
+					"type": "sweep",
 				})
 			})
 		}
@@ -123,12 +133,15 @@ func NewFromConnection(dbName string,dbConn *mongo.Database) error {
 
 // WithBus take ksbus.NewServer() that can be Run, RunTLS, RunAutoTLS
 func WithBus(bus *ksbus.Server) *ksbus.Server {
+	cacheBusMu.Lock()
 	cachebus = bus.Bus
+	cacheServer = bus
+	cacheBusMu.Unlock()
 	if useCache {
 		cachebus.Subscribe(CACHE_TOPIC, func(data map[string]any, ch ksbus.Channel) { handleCache(data) })
 		go RunEvery(FlushCacheEvery, func() {
 			cachebus.Publish(CACHE_TOPIC, map[string]any{
-				"type": "clean",
+				"type": "sweep",
 			})
 		})
 	}
@@ -145,10 +158,13 @@ func BeforeDataWS(fn func(data map[string]any, conn *ws.Conn, originalRequest *h
 	ksbus.BeforeDataWS = fn
 }
 
-// FlushCache send msg to the cache system to Flush all the cache, safe to use in concurrent mode, and safe to use in general, it's done every 30 minutes(korm.FlushCacheEvery) and on update , create, delete , drop
+// FlushCache send msg to the cache system to Flush all the cache, safe to use in concurrent mode, and safe to use in general, it's done on update , create, delete , drop
 func FlushCache() {
-	go cachebus.Publish(CACHE_TOPIC, map[string]any{
+	cacheGetAllTables.Flush()
+	flushQueryCaches()
+	go publishCache(map[string]any{
 		"type": "clean",
+		"from": cacheOrigin,
 	})
 }
 
@@ -167,7 +183,8 @@ func GetConnection(dbName ...string) (*mongo.Database, bool) {
 	} else {
 		db, err = GetMemoryDatabase(databases[0].Name)
 	}
-	if klog.CheckError(err) {
+	if err != nil {
+		logger.Error(err.Error())
 		return nil, false
 	}
 	if db.MongoConn != nil {
@@ -220,7 +237,7 @@ func GetMemoryTable(tbName string, dbName ...string) (TableEntity, error) {
 			return t, nil
 		}
 	}
-	return TableEntity{}, errors.New("nothing found")
+	return TableEntity{}, ErrNotFound
 }
 
 // GetMemoryTable get all tables from memory for specified or first connected db
@@ -253,14 +270,14 @@ func GetMemoryDatabase(dbName string) (*DatabaseEntity, error) {
 				return &databases[i], nil
 			}
 		}
-		return nil, errors.New(dbName + "database not found")
+		return nil, fmt.Errorf("%s: %w", dbName, ErrDatabaseNotFound)
 	default:
 		for i := range databases {
 			if databases[i].Name == dbName {
 				return &databases[i], nil
 			}
 		}
-		return nil, errors.New(dbName + "database not found")
+		return nil, fmt.Errorf("%s: %w", dbName, ErrDatabaseNotFound)
 	}
 }
 
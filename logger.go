@@ -0,0 +1,127 @@
+package kormongo
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kamalshkeir/klog"
+)
+
+// Logger is used by kormongo for all its logs, *slog.Logger implement it, so SetLogger(slog.Default()) works as is
+type Logger interface {
+	Debug(msg string, args ...any)
+	Info(msg string, args ...any)
+	Warn(msg string, args ...any)
+	Error(msg string, args ...any)
+}
+
+// logger default to colored logs using klog
+var logger Logger = klogLogger{}
+
+// SetLogger set the logger used by kormongo, nil disable all logs, should be called before korm.New
+func SetLogger(l Logger) {
+	if l == nil {
+		l = nopLogger{}
+	}
+	logger = l
+}
+
+type klogLogger struct{}
+
+func (klogLogger) Debug(msg string, args ...any) { klog.Printf("bl%s\n", formatLog(msg, args)) }
+func (klogLogger) Info(msg string, args ...any)  { klog.Printf("gr%s\n", formatLog(msg, args)) }
+func (klogLogger) Warn(msg string, args ...any)  { klog.Printf("yl%s\n", formatLog(msg, args)) }
+func (klogLogger) Error(msg string, args ...any) { klog.Printf("rd%s\n", formatLog(msg, args)) }
+
+// formatLog format msg followed by args as key=value pairs
+func formatLog(msg string, args []any) string {
+	var b strings.Builder
+	b.WriteString(msg)
+	for i := 0; i < len(args); i += 2 {
+		if i+1 < len(args) {
+			fmt.Fprintf(&b, " %v=%v", args[i], args[i+1])
+		} else {
+			fmt.Fprintf(&b, " %v", args[i])
+		}
+	}
+	return b.String()
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, ...any) {}
+func (nopLogger) Info(string, ...any)  {}
+func (nopLogger) Warn(string, ...any)  {}
+func (nopLogger) Error(string, ...any) {}
+
+const (
+	cacheOff  = "off"
+	cacheHit  = "hit"
+	cacheMiss = "miss"
+)
+
+// queryLog describe a builder operation, logged when done if Debug is enabled on the builder or globally
+type queryLog struct {
+	debug      bool
+	op         string
+	database   string
+	table      string
+	filter     map[string]any
+	projection string
+	sort       string
+	cache      string
+	start      time.Time
+	slow       time.Duration
+}
+
+func newQueryLog(debug bool, slow time.Duration, op, database, table string) *queryLog {
+	return &queryLog{
+		debug:    debug,
+		op:       op,
+		database: database,
+		table:    table,
+		cache:    cacheOff,
+		start:    time.Now(),
+		slow:     slow,
+	}
+}
+
+// done log the operation with the number of documents returned or affected
+func (q *queryLog) done(docs int, err error) {
+	d := time.Since(q.start)
+	recordOp(q.database, q.table, q.op, d, err)
+	q.checkSlow(d)
+	if !q.debug && !Debug {
+		return
+	}
+	args := []any{
+		"op", q.op,
+		"database", q.database,
+		"table", q.table,
+		"filter", q.filter,
+	}
+	if q.projection != "" {
+		args = append(args, "projection", q.projection)
+	}
+	if q.sort != "" {
+		args = append(args, "sort", q.sort)
+	}
+	args = append(args, "duration", d, "docs", docs, "cache", q.cache)
+	if err != nil {
+		args = append(args, "error", err)
+		logger.Error("kormongo query", args...)
+		return
+	}
+	logger.Info("kormongo query", args...)
+}
+
+// cacheLookup set the result of the lookup in the cache name
+func (q *queryLog) cacheLookup(name string, hit bool) {
+	if hit {
+		q.cache = cacheHit
+	} else {
+		q.cache = cacheMiss
+	}
+	recordCacheLookup(name, q.database, q.table, hit)
+}
@@ -0,0 +1,195 @@
+package kormongo
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// MetricsBuckets are the upper bounds in seconds of the operations duration histogram
+var MetricsBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type opKey struct {
+	database string
+	table    string
+	op       string
+}
+
+type opStats struct {
+	count   uint64
+	errors  uint64
+	sum     float64
+	buckets []uint64
+}
+
+type cacheKey struct {
+	cache    string
+	database string
+	table    string
+}
+
+type cacheCounters struct {
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+var (
+	metricsMu    sync.Mutex
+	opsMetrics   = map[opKey]*opStats{}
+	cacheMetrics = map[cacheKey]*cacheCounters{}
+)
+
+// recordOp add an operation to the metrics
+func recordOp(database, table, op string, d time.Duration, err error) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	k := opKey{database: database, table: table, op: op}
+	st, ok := opsMetrics[k]
+	if !ok {
+		st = &opStats{buckets: make([]uint64, len(MetricsBuckets))}
+		opsMetrics[k] = st
+	}
+	st.count++
+	if err != nil {
+		st.errors++
+	}
+	secs := d.Seconds()
+	st.sum += secs
+	for i, le := range MetricsBuckets {
+		if i < len(st.buckets) && secs <= le {
+			st.buckets[i]++
+		}
+	}
+}
+
+func cacheCountersFor(cache, database, table string) *cacheCounters {
+	k := cacheKey{cache: cache, database: database, table: table}
+	c, ok := cacheMetrics[k]
+	if !ok {
+		c = &cacheCounters{}
+		cacheMetrics[k] = c
+	}
+	return c
+}
+
+// recordCacheLookup count a hit or a miss of the cache
+func recordCacheLookup(cache, database, table string, hit bool) {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	c := cacheCountersFor(cache, database, table)
+	if hit {
+		c.hits++
+	} else {
+		c.misses++
+	}
+}
+
+// recordCacheEviction count entries removed from the cache
+func recordCacheEviction(cache, database, table string, n int) {
+	if n <= 0 {
+		return
+	}
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+	cacheCountersFor(cache, database, table).evictions += uint64(n)
+}
+
+// cacheSizes return the number of entries and the approximate memory of the in-process query caches
+func cacheSizes() (map[string]int, map[string]int64) {
+	sizes, bytes := map[string]int{}, map[string]int64{}
+	for _, name := range queryCacheNames {
+		if lru, ok := queryCache(name).(*lruCache); ok {
+			sizes[name], bytes[name] = lru.Len(), lru.Bytes()
+		}
+	}
+	return sizes, bytes
+}
+
+// MetricsHandler return an http.Handler exposing operations and cache metrics in the prometheus text format
+func MetricsHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WriteMetrics(w)
+	})
+}
+
+// WriteMetrics write operations and cache metrics in the prometheus text format
+func WriteMetrics(w io.Writer) {
+	metricsMu.Lock()
+	ops := make([]opKey, 0, len(opsMetrics))
+	for k := range opsMetrics {
+		ops = append(ops, k)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].database+ops[i].table+ops[i].op < ops[j].database+ops[j].table+ops[j].op
+	})
+	caches := make([]cacheKey, 0, len(cacheMetrics))
+	for k := range cacheMetrics {
+		caches = append(caches, k)
+	}
+	sort.Slice(caches, func(i, j int) bool {
+		return caches[i].cache+caches[i].database+caches[i].table < caches[j].cache+caches[j].database+caches[j].table
+	})
+
+	var b strings.Builder
+	b.WriteString("# HELP kormongo_operations_total Number of builders operations.\n# TYPE kormongo_operations_total counter\n")
+	for _, k := range ops {
+		fmt.Fprintf(&b, "kormongo_operations_total{%s} %d\n", opLabels(k), opsMetrics[k].count)
+	}
+	b.WriteString("# HELP kormongo_operation_errors_total Number of builders operations that returned an error.\n# TYPE kormongo_operation_errors_total counter\n")
+	for _, k := range ops {
+		fmt.Fprintf(&b, "kormongo_operation_errors_total{%s} %d\n", opLabels(k), opsMetrics[k].errors)
+	}
+	b.WriteString("# HELP kormongo_operation_duration_seconds Duration of builders operations.\n# TYPE kormongo_operation_duration_seconds histogram\n")
+	for _, k := range ops {
+		st := opsMetrics[k]
+		labels := opLabels(k)
+		for i, le := range MetricsBuckets {
+			if i < len(st.buckets) {
+				fmt.Fprintf(&b, "kormongo_operation_duration_seconds_bucket{%s,le=\"%g\"} %d\n", labels, le, st.buckets[i])
+			}
+		}
+		fmt.Fprintf(&b, "kormongo_operation_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels, st.count)
+		fmt.Fprintf(&b, "kormongo_operation_duration_seconds_sum{%s} %g\n", labels, st.sum)
+		fmt.Fprintf(&b, "kormongo_operation_duration_seconds_count{%s} %d\n", labels, st.count)
+	}
+	for _, m := range []struct {
+		name, help string
+		value      func(c *cacheCounters) uint64
+	}{
+		{"kormongo_cache_hits_total", "Number of queries served from the cache.", func(c *cacheCounters) uint64 { return c.hits }},
+		{"kormongo_cache_misses_total", "Number of queries not found in the cache.", func(c *cacheCounters) uint64 { return c.misses }},
+		{"kormongo_cache_evictions_total", "Number of entries removed from the cache.", func(c *cacheCounters) uint64 { return c.evictions }},
+	} {
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s counter\n", m.name, m.help, m.name)
+		for _, k := range caches {
+			fmt.Fprintf(&b, "%s{cache=%q,database=%q,table=%q} %d\n", m.name, k.cache, k.database, k.table, m.value(cacheMetrics[k]))
+		}
+	}
+	metricsMu.Unlock()
+
+	b.WriteString("# HELP kormongo_cache_entries Number of entries in the cache.\n# TYPE kormongo_cache_entries gauge\n")
+	sizes, bytes := cacheSizes()
+	names := make([]string, 0, len(sizes))
+	for name := range sizes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&b, "kormongo_cache_entries{cache=%q} %d\n", name, sizes[name])
+	}
+	b.WriteString("# HELP kormongo_cache_bytes Approximate memory used by the cache in bytes.\n# TYPE kormongo_cache_bytes gauge\n")
+	for _, name := range names {
+		fmt.Fprintf(&b, "kormongo_cache_bytes{cache=%q} %d\n", name, bytes[name])
+	}
+	io.WriteString(w, b.String())
+}
+
+func opLabels(k opKey) string {
+	return fmt.Sprintf("database=%q,table=%q,op=%q", k.database, k.table, k.op)
+}
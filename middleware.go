@@ -0,0 +1,58 @@
+package kormongo
+
+import (
+	"context"
+	"sync"
+)
+
+// OpInfo describe a builder operation given to the middlewares, Ctx and Filter can be replaced before calling next, ex: tracing spans or tenant scoping
+type OpInfo struct {
+	Ctx context.Context
+	// Kind is one of find, findOne, count, explain, insert, update, replace, delete, restore, drop
+	Kind     string
+	Database string
+	Table    string
+	// Filter is the filter sent to mongo, nil for insert and drop
+	Filter map[string]any
+	// Cache is 'off', 'hit' or 'miss' once next returned, a hit mean the result came from the cache without reaching mongo
+	Cache string
+}
+
+// Op execute the operation described by op
+type Op func(op *OpInfo) error
+
+var (
+	middlewaresMu sync.RWMutex
+	middlewares   []func(next Op) Op
+)
+
+// Use add middlewares wrapping every builder operation including the cache lookups, the first added is the outermost
+//
+//	kormongo.Use(func(next kormongo.Op) kormongo.Op {
+//		return func(op *kormongo.OpInfo) error {
+//			ctx, span := tracer.Start(op.Ctx, op.Kind+" "+op.Table)
+//			defer span.End()
+//			op.Ctx = ctx
+//			return next(op)
+//		}
+//	})
+func Use(mw ...func(next Op) Op) {
+	middlewaresMu.Lock()
+	defer middlewaresMu.Unlock()
+	middlewares = append(middlewares[:len(middlewares):len(middlewares)], mw...)
+}
+
+// runOp run final wrapped by the middlewares
+func runOp(op *OpInfo, final Op) error {
+	middlewaresMu.RLock()
+	mws := middlewares
+	middlewaresMu.RUnlock()
+	if op.Cache == "" {
+		op.Cache = cacheOff
+	}
+	next := final
+	for i := len(mws) - 1; i >= 0; i-- {
+		next = mws[i](next)
+	}
+	return next(op)
+}
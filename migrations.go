@@ -0,0 +1,293 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+const (
+	MIGRATIONS_TABLE      = "_migrations"
+	MIGRATIONS_LOCK_TABLE = "_migrations_lock"
+)
+
+var (
+	// MigrationLockTimeout after this duration a lock left by a crashed instance is considered expired, it is renewed while the migrations run
+	MigrationLockTimeout = 10 * time.Minute
+	migrations           = []Migration{}
+	errMigrationLockLost = errors.New("migrations lock lost, another instance may be running them")
+)
+
+// Migration is a versioned migration registered using RegisterMigration
+type Migration struct {
+	Id   string
+	Up   func(ctx context.Context, db *mongo.Database) error
+	Down func(ctx context.Context, db *mongo.Database) error
+}
+
+// MigrationState is the state of a registered or applied migration returned by MigrationStatus
+type MigrationState struct {
+	Id         string
+	Applied    bool
+	AppliedAt  time.Time
+	Seq        int64
+	Registered bool
+}
+
+type migrationRecord struct {
+	Id        string    `bson:"_id"`
+	Seq       int64     `bson:"seq"`
+	AppliedAt time.Time `bson:"applied_at"`
+}
+
+// RegisterMigration register a migration, migrations are applied in the order they are registered, register them before korm.New to use them from the command line
+func RegisterMigration(id string, up, down func(ctx context.Context, db *mongo.Database) error) {
+	for _, m := range migrations {
+		if m.Id == id {
+			panic("kormongo: migration " + id + " registered twice")
+		}
+	}
+	migrations = append(migrations, Migration{
+		Id:   id,
+		Up:   up,
+		Down: down,
+	})
+}
+
+// Migrate apply all pending migrations for the optional dbName given, otherwise the first connected database
+func Migrate(dbName ...string) error {
+	return withMigrationLock(func(ctx context.Context, db *mongo.Database, lock *migrationLock) error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		seq := int64(0)
+		for _, r := range applied {
+			if r.Seq > seq {
+				seq = r.Seq
+			}
+		}
+		for _, m := range migrations {
+			if _, ok := applied[m.Id]; ok {
+				continue
+			}
+			if m.Up != nil {
+				if err := m.Up(ctx, db); err != nil {
+					return fmt.Errorf("migration %s failed: %w", m.Id, err)
+				}
+			}
+			if err := lock.renew(ctx); err != nil {
+				return fmt.Errorf("migration %s applied but not recorded: %w", m.Id, err)
+			}
+			seq++
+			_, err := db.Collection(MIGRATIONS_TABLE).InsertOne(ctx, migrationRecord{
+				Id:        m.Id,
+				Seq:       seq,
+				AppliedAt: time.Now().UTC(),
+			})
+			if err != nil {
+				return err
+			}
+			if Debug {
+				logger.Info("migration applied", "id", m.Id)
+			}
+		}
+		return nil
+	}, dbName...)
+}
+
+// Rollback revert the last n applied migrations, in reverse order, n must be positive
+func Rollback(n int, dbName ...string) error {
+	if n <= 0 {
+		return fmt.Errorf("rollback: invalid number of migrations %d, it must be positive", n)
+	}
+	return withMigrationLock(func(ctx context.Context, db *mongo.Database, lock *migrationLock) error {
+		applied, err := appliedMigrations(ctx, db)
+		if err != nil {
+			return err
+		}
+		records := make([]migrationRecord, 0, len(applied))
+		for _, r := range applied {
+			records = append(records, r)
+		}
+		sort.Slice(records, func(i, j int) bool {
+			return records[i].Seq > records[j].Seq
+		})
+		if n < len(records) {
+			records = records[:n]
+		}
+		for _, r := range records {
+			var m *Migration
+			for i := range migrations {
+				if migrations[i].Id == r.Id {
+					m = &migrations[i]
+					break
+				}
+			}
+			if m == nil {
+				return fmt.Errorf("migration %s is applied but not registered", r.Id)
+			}
+			if m.Down != nil {
+				if err := m.Down(ctx, db); err != nil {
+					return fmt.Errorf("rollback of %s failed: %w", m.Id, err)
+				}
+			}
+			if err := lock.renew(ctx); err != nil {
+				return fmt.Errorf("migration %s rolled back but still recorded: %w", m.Id, err)
+			}
+			_, err := db.Collection(MIGRATIONS_TABLE).DeleteOne(ctx, bson.M{"_id": r.Id})
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}, dbName...)
+}
+
+// MigrationStatus return registered migrations in order followed by applied migrations that are not registered anymore
+func MigrationStatus(dbName ...string) ([]MigrationState, error) {
+	db, err := migrationsDatabase(dbName...)
+	if err != nil {
+		return nil, err
+	}
+	applied, err := appliedMigrations(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+	res := []MigrationState{}
+	for _, m := range migrations {
+		st := MigrationState{
+			Id:         m.Id,
+			Registered: true,
+		}
+		if r, ok := applied[m.Id]; ok {
+			st.Applied = true
+			st.AppliedAt = r.AppliedAt
+			st.Seq = r.Seq
+			delete(applied, m.Id)
+		}
+		res = append(res, st)
+	}
+	unknown := []MigrationState{}
+	for _, r := range applied {
+		unknown = append(unknown, MigrationState{
+			Id:        r.Id,
+			Applied:   true,
+			AppliedAt: r.AppliedAt,
+			Seq:       r.Seq,
+		})
+	}
+	sort.Slice(unknown, func(i, j int) bool {
+		return unknown[i].Seq < unknown[j].Seq
+	})
+	return append(res, unknown...), nil
+}
+
+func migrationsDatabase(dbName ...string) (*mongo.Database, error) {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := GetMemoryDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+	return db.MongoConn, nil
+}
+
+func appliedMigrations(ctx context.Context, db *mongo.Database) (map[string]migrationRecord, error) {
+	cur, err := db.Collection(MIGRATIONS_TABLE).Find(ctx, bson.M{})
+	if err != nil {
+		return nil, err
+	}
+	records := []migrationRecord{}
+	if err := cur.All(ctx, &records); err != nil {
+		return nil, err
+	}
+	res := make(map[string]migrationRecord, len(records))
+	for _, r := range records {
+		res[r.Id] = r
+	}
+	return res, nil
+}
+
+// migrationLock is the migrations lock held by this instance
+type migrationLock struct {
+	db    *mongo.Database
+	owner string
+}
+
+// renew extend the lock by MigrationLockTimeout, errMigrationLockLost if it expired and another instance took it
+func (l *migrationLock) renew(ctx context.Context) error {
+	res, err := l.db.Collection(MIGRATIONS_LOCK_TABLE).UpdateOne(ctx,
+		bson.M{"_id": "lock", "owner": l.owner},
+		bson.M{"$set": bson.M{"expires_at": time.Now().UTC().Add(MigrationLockTimeout)}},
+	)
+	if err != nil {
+		return err
+	}
+	if res.MatchedCount == 0 {
+		return errMigrationLockLost
+	}
+	return nil
+}
+
+// withMigrationLock run fn holding the migrations lock, so only one instance run migrations at a time
+// the lock is renewed every third of MigrationLockTimeout while fn run, ctx is canceled if it is lost
+func withMigrationLock(fn func(ctx context.Context, db *mongo.Database, lock *migrationLock) error, dbName ...string) error {
+	db, err := migrationsDatabase(dbName...)
+	if err != nil {
+		return err
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d-%d", host, os.Getpid(), time.Now().UnixNano())
+	now := time.Now().UTC()
+	// the filter only match an expired lock, if the lock is held the upsert fail with a duplicate key error
+	_, err = db.Collection(MIGRATIONS_LOCK_TABLE).UpdateOne(context.Background(),
+		bson.M{"_id": "lock", "expires_at": bson.M{"$lt": now}},
+		bson.M{"$set": bson.M{"owner": owner, "locked_at": now, "expires_at": now.Add(MigrationLockTimeout)}},
+		options.Update().SetUpsert(true),
+	)
+	if mongo.IsDuplicateKeyError(err) {
+		return errors.New("migrations are locked by another instance")
+	} else if err != nil {
+		return err
+	}
+	lock := &migrationLock{db: db, owner: owner}
+	ctx, cancel := context.WithCancel(context.Background())
+	heartbeat := sync.WaitGroup{}
+	heartbeat.Add(1)
+	go func() {
+		defer heartbeat.Done()
+		t := time.NewTicker(MigrationLockTimeout / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+				if err := lock.renew(ctx); errors.Is(err, errMigrationLockLost) {
+					logger.Error("migrations: lock lost, aborting", "owner", owner)
+					cancel()
+					return
+				} else if err != nil && ctx.Err() == nil {
+					logger.Warn("migrations: unable to renew the lock", "error", err)
+				}
+			}
+		}
+	}()
+	defer func() {
+		cancel()
+		heartbeat.Wait()
+		db.Collection(MIGRATIONS_LOCK_TABLE).DeleteOne(context.Background(), bson.M{"_id": "lock", "owner": owner})
+	}()
+	return fn(ctx, db, lock)
+}
@@ -4,10 +4,23 @@ import (
 	"context"
 	"errors"
 
-	"github.com/kamalshkeir/kmongodriver"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
+// namespaceExists is the mongo error code of CreateCollection when the collection already exist
+const namespaceExists = 48
+
+// AutoMigrate create the collection tableName if not exist and link the model T to it
+// unless ValidationLevel is 'off', the collection is created with a $jsonSchema validator generated from T, existing collections get it only if UpdateValidators is true
+// korm tags handled by the validator: 'required', 'enum:a,b', 'min:1', 'max:10', 'pattern:^[a-z]+$'
 func AutoMigrate[T comparable](tableName string, dbName ...string) error {
+	return AutoMigrateWithOptions[T](tableName, nil, dbName...)
+}
+
+// AutoMigrateWithOptions same as AutoMigrate, opts are used when creating the collection (capped, collation, validator, time-series...)
+func AutoMigrateWithOptions[T comparable](tableName string, opts *options.CreateCollectionOptions, dbName ...string) error {
 	if _, ok := mModelTablename[*new(T)]; !ok {
 		mModelTablename[*new(T)] = tableName
 	}
@@ -18,13 +31,13 @@ func AutoMigrate[T comparable](tableName string, dbName ...string) error {
 		dbname = dbName[0]
 		db, err = GetMemoryDatabase(dbname)
 		if err != nil || db == nil {
-			return errors.New("database not found")
+			return ErrDatabaseNotFound
 		}
 	} else if len(dbName) == 0 {
 		dbname = databases[0].Name
 		db, err = GetMemoryDatabase(dbname)
 		if err != nil || db == nil {
-			return errors.New("database not found")
+			return ErrDatabaseNotFound
 		}
 	} else {
 		return errors.New("cannot migrate more than one database at the same time")
@@ -37,8 +50,36 @@ func AutoMigrate[T comparable](tableName string, dbName ...string) error {
 			tbFoundDB = true
 		}
 	}
+	// time-series collections don't support validators
+	useValidator := ValidationLevel != "off" && (opts == nil || (opts.Validator == nil && opts.TimeSeriesOptions == nil))
 	if !tbFoundDB {
-		kmongodriver.CreateRow(context.Background(), tableName, new(T))
+		if useValidator {
+			if opts == nil {
+				opts = options.CreateCollection()
+			} else {
+				o := *opts
+				opts = &o
+			}
+			opts.SetValidator(modelSchema[T]()).SetValidationLevel(ValidationLevel).SetValidationAction(ValidationAction)
+		}
+		err = db.MongoConn.CreateCollection(context.Background(), tableName, opts)
+		// created meanwhile by another instance, or missing from the cached list of the tables
+		var se mongo.ServerError
+		if err != nil && !(errors.As(err, &se) && se.HasErrorCode(namespaceExists)) {
+			return err
+		}
+		cacheGetAllTables.Delete(dbname)
+	} else if useValidator && UpdateValidators {
+		err = db.MongoConn.RunCommand(context.Background(), bson.D{
+			{Key: "collMod", Value: tableName},
+			{Key: "validator", Value: modelSchema[T]()},
+			{Key: "validationLevel", Value: ValidationLevel},
+			{Key: "validationAction", Value: ValidationAction},
+		}).Err()
+		if err != nil {
+			return err
+		}
 	}
+	setMemoryTable(db, tableEntityFromModel[T](tableName))
 	return nil
 }
@@ -0,0 +1,97 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// NotFoundCacheTTL cache for this duration the One queries matching no document, 0 disable it, override it per query using CacheNotFound
+// useful for lookups repeated with values that don't exist, ex: tokens, inserts and updates of the table still invalidate these entries
+var NotFoundCacheTTL time.Duration = 0
+
+// oneResult is the cached result of One, NotFound when no document matched
+type oneResult[T any] struct {
+	Doc      T    `bson:"doc"`
+	NotFound bool `bson:"not_found"`
+}
+
+// fetchOne call fetch, a not found error become a NotFound result when negative is true so it can be cached
+func fetchOne[T any](ctx context.Context, fetch func(ctx context.Context) (T, error), negative bool) (oneResult[T], error) {
+	res, err := fetch(ctx)
+	if negative && errors.Is(err, ErrNotFound) {
+		return oneResult[T]{NotFound: true}, nil
+	}
+	return oneResult[T]{Doc: res}, err
+}
+
+// notFoundTTL return the ttl of the not found results, CacheNotFound or NotFoundCacheTTL
+func notFoundTTL(ttl time.Duration) time.Duration {
+	if ttl != 0 {
+		return ttl
+	}
+	return NotFoundCacheTTL
+}
+
+// OneOrNil is One returning nil instead of ErrNotFound when no document match
+func (b *Builder[T]) OneOrNil() (*T, error) {
+	res, err := b.One()
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// First return the first document matching Where ordered by _id, or by OrderBy if used
+func (b *Builder[T]) First() (T, error) {
+	if b.orderBys == "" {
+		b.OrderBy("_id")
+	}
+	return b.One()
+}
+
+// FirstOrCreate return the first document matching Where, or insert model and return the inserted document read back using Where, with its _id
+// if a concurrent call inserted it first and a unique index reject model, the document it inserted is returned
+func (b *Builder[T]) FirstOrCreate(model *T) (T, error) {
+	res, err := b.First()
+	if !errors.Is(err, ErrNotFound) {
+		return res, err
+	}
+	if _, err := b.Insert(model); err != nil && !errors.Is(err, ErrDuplicateKey) {
+		return *new(T), err
+	}
+	return b.Refresh().First()
+}
+
+// OneOrNil is One returning nil instead of ErrNotFound when no document match
+func (b *BuilderM) OneOrNil() (map[string]any, error) {
+	res, err := b.One()
+	if errors.Is(err, ErrNotFound) {
+		return nil, nil
+	}
+	return res, err
+}
+
+// First return the first document matching Where ordered by _id, or by OrderBy if used
+func (b *BuilderM) First() (map[string]any, error) {
+	if b.orderBys == "" {
+		b.OrderBy("_id")
+	}
+	return b.One()
+}
+
+// FirstOrCreate return the first document matching Where, or insert the fields and return the inserted document read back using Where
+//
+//	korm.Table("tokens").Where("user_id", id).FirstOrCreate("user_id,token", id, token)
+func (b *BuilderM) FirstOrCreate(fieldsCommaSeparated string, fields_values ...any) (map[string]any, error) {
+	res, err := b.First()
+	if !errors.Is(err, ErrNotFound) {
+		return res, err
+	}
+	if _, err := b.Insert(fieldsCommaSeparated, fields_values...); err != nil && !errors.Is(err, ErrDuplicateKey) {
+		return nil, err
+	}
+	return b.Refresh().First()
+}
@@ -0,0 +1,79 @@
+package kormongo
+
+import (
+	"context"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// projectionDoc convert 'email, password' to a projection document, nil if selected is empty
+func projectionDoc(selected string) bson.D {
+	if strings.TrimSpace(selected) == "" {
+		return nil
+	}
+	proj := bson.D{}
+	for _, p := range strings.Split(selected, ",") {
+		proj = append(proj, bson.E{Key: strings.TrimSpace(p), Value: 1})
+	}
+	return proj
+}
+
+// findAll return the documents matching filter using projection, sort, limit and page as given to the builders
+func findAll[T any](ctx context.Context, db *mongo.Database, table string, filter map[string]any, selected, orderBy string, limit, page int64) ([]T, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	opts := options.Find()
+	if proj := projectionDoc(selected); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if s := sortDoc(orderBy); len(s) > 0 {
+		opts.SetSort(s)
+	}
+	if limit > 0 {
+		opts.SetLimit(limit)
+		if page > 1 {
+			opts.SetSkip(limit * (page - 1))
+		}
+	}
+	cursor, err := db.Collection(table).Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := []T{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// findOne return the first document matching filter, mongo.ErrNoDocuments if none matched
+func findOne[T any](ctx context.Context, db *mongo.Database, table string, filter map[string]any, selected, orderBy string, limit, page int64) (T, error) {
+	if filter == nil {
+		filter = map[string]any{}
+	}
+	opts := options.FindOne()
+	if proj := projectionDoc(selected); proj != nil {
+		opts.SetProjection(proj)
+	}
+	if s := sortDoc(orderBy); len(s) > 0 {
+		opts.SetSort(s)
+	}
+	if limit > 0 && page > 1 {
+		opts.SetSkip(limit * (page - 1))
+	}
+	res := *new(T)
+	if err := db.Collection(table).FindOne(ctx, filter, opts).Decode(&res); err != nil {
+		return *new(T), err
+	}
+	return res, nil
+}
+
+// insertOne insert doc in table
+func insertOne(ctx context.Context, db *mongo.Database, table string, doc any) error {
+	_, err := db.Collection(table).InsertOne(ctx, doc)
+	return err
+}
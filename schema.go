@@ -0,0 +1,402 @@
+package kormongo
+
+import (
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsoncodec"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// modelField describe a struct field as it is stored in mongo
+type modelField struct {
+	Name      string
+	Column    string
+	Index     []int
+	Type      reflect.Type
+	Tags      []string
+	OmitEmpty bool
+}
+
+var mModelFields sync.Map // reflect.Type -> []modelField
+
+var (
+	timeType     = reflect.TypeOf(time.Time{})
+	objectIdType = reflect.TypeOf(primitive.ObjectID{})
+	dateTimeType = reflect.TypeOf(primitive.DateTime(0))
+	// bsonStructTypes are the driver types encoded as a bson type instead of a document
+	bsonStructTypes = map[reflect.Type]string{
+		reflect.TypeOf(primitive.Decimal128{}):   "decimal",
+		reflect.TypeOf(primitive.Binary{}):       "binData",
+		reflect.TypeOf(primitive.Timestamp{}):    "timestamp",
+		reflect.TypeOf(primitive.Regex{}):        "regex",
+		reflect.TypeOf(primitive.JavaScript("")): "javascript",
+		reflect.TypeOf(primitive.Symbol("")):     "symbol",
+		reflect.TypeOf(primitive.MinKey{}):       "minKey",
+		reflect.TypeOf(primitive.MaxKey{}):       "maxKey",
+	}
+	marshalerType      = reflect.TypeOf((*bson.Marshaler)(nil)).Elem()
+	valueMarshalerType = reflect.TypeOf((*bsoncodec.ValueMarshaler)(nil)).Elem()
+)
+
+// customBSON report if values of t encode themselves, their bson type is unknown
+func customBSON(t reflect.Type) bool {
+	return t.Implements(marshalerType) || t.Implements(valueMarshalerType) ||
+		reflect.PointerTo(t).Implements(marshalerType) || reflect.PointerTo(t).Implements(valueMarshalerType)
+}
+
+// modelFields return the fields of a struct type following the bson codec rules, results are cached per type
+func modelFields(t reflect.Type) []modelField {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	if v, ok := mModelFields.Load(t); ok {
+		return v.([]modelField)
+	}
+	fields := []modelField{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("bson")
+		if tag == "-" {
+			continue
+		}
+		column := strings.ToLower(f.Name)
+		omitEmpty, inline := false, false
+		if tag != "" {
+			sp := strings.Split(tag, ",")
+			if sp[0] != "" {
+				column = sp[0]
+			}
+			for _, opt := range sp[1:] {
+				switch opt {
+				case "omitempty":
+					omitEmpty = true
+				case "inline":
+					inline = true
+				}
+			}
+		}
+		if inline {
+			ft := f.Type
+			for ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			for _, sub := range modelFields(ft) {
+				sub.Index = append([]int{i}, sub.Index...)
+				fields = append(fields, sub)
+			}
+			continue
+		}
+		tags := []string{}
+		if kt := f.Tag.Get("korm"); kt != "" {
+			for _, s := range strings.Split(kt, ";") {
+				if s = strings.TrimSpace(s); s != "" {
+					tags = append(tags, s)
+				}
+			}
+		}
+		fields = append(fields, modelField{
+			Name:      f.Name,
+			Column:    column,
+			Index:     []int{i},
+			Type:      f.Type,
+			Tags:      tags,
+			OmitEmpty: omitEmpty,
+		})
+	}
+	mModelFields.Store(t, fields)
+	return fields
+}
+
+// hasTag check if the korm tag contain tag, 'required' match 'required' and 'enum:a,b' match 'enum'
+func (f modelField) hasTag(tag string) bool {
+	_, ok := f.tagValue(tag)
+	return ok
+}
+
+// tagValue return the value of korm tag 'key:value'
+func (f modelField) tagValue(key string) (string, bool) {
+	for _, t := range f.Tags {
+		if t == key {
+			return "", true
+		}
+		if strings.HasPrefix(t, key+":") {
+			return t[len(key)+1:], true
+		}
+	}
+	return "", false
+}
+
+// columnType return the type name of a go type, same names returned by GetAllColumnsTypes
+func columnType(t reflect.Type) string {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t {
+	case timeType, dateTimeType:
+		return "timestampz"
+	case objectIdType:
+		return "primitive.ObjectID"
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return "int"
+	case reflect.Float32, reflect.Float64:
+		return "float"
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return "binary"
+		}
+		return "array"
+	case reflect.Struct, reflect.Map:
+		return "object"
+	default:
+		return t.String()
+	}
+}
+
+// tableEntityFromModel build the TableEntity of a model from its struct fields
+func tableEntityFromModel[T comparable](tableName string) TableEntity {
+	return tableEntityFromType(reflect.TypeOf(new(T)).Elem(), tableName)
+}
+
+// tableEntityFromType build the TableEntity of a struct type
+func tableEntityFromType(t reflect.Type, tableName string) TableEntity {
+	te := TableEntity{
+		Name:       tableName,
+		Pk:         "_id",
+		Columns:    []string{},
+		Types:      map[string]string{},
+		ModelTypes: map[string]string{},
+		Tags:       map[string][]string{},
+	}
+	for _, f := range modelFields(t) {
+		te.Columns = append(te.Columns, f.Column)
+		te.Types[f.Column] = columnType(f.Type)
+		te.ModelTypes[f.Column] = f.Type.String()
+		te.Tags[f.Column] = f.Tags
+		if f.Column == "_id" || f.hasTag("pk") {
+			te.Pk = f.Column
+		}
+	}
+	return te
+}
+
+// setMemoryTable add or replace a table in memory for the database
+func setMemoryTable(db *DatabaseEntity, te TableEntity) {
+	for i := range db.Tables {
+		if db.Tables[i].Name == te.Name {
+			db.Tables[i] = te
+			return
+		}
+	}
+	db.Tables = append(db.Tables, te)
+}
+
+// bsonTypes return the $jsonSchema bsonType of a go type, pointers, slices and maps can also be null
+func bsonTypes(t reflect.Type) []string {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	var types []string
+	switch t {
+	case timeType, dateTimeType:
+		types = []string{"date"}
+	case objectIdType:
+		types = []string{"objectId"}
+	default:
+		if bt, ok := bsonStructTypes[t]; ok {
+			types = []string{bt}
+		} else if customBSON(t) {
+			return nil
+		}
+	}
+	if types == nil {
+		switch t.Kind() {
+		case reflect.String:
+			types = []string{"string"}
+		case reflect.Bool:
+			types = []string{"bool"}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			types = []string{"int", "long"}
+		case reflect.Float32, reflect.Float64:
+			types = []string{"number"}
+		case reflect.Slice:
+			nullable = true
+			if t.Elem().Kind() == reflect.Uint8 {
+				types = []string{"binData"}
+			} else {
+				types = []string{"array"}
+			}
+		case reflect.Array:
+			// [N]byte like uuid.UUID are encoded as binary
+			if t.Elem().Kind() == reflect.Uint8 {
+				types = []string{"binData"}
+			} else {
+				types = []string{"array"}
+			}
+		case reflect.Map:
+			nullable = true
+			types = []string{"object"}
+		case reflect.Struct:
+			types = []string{"object"}
+		default:
+			return nil
+		}
+	}
+	if nullable {
+		types = append(types, "null")
+	}
+	return types
+}
+
+// fieldSchema return the $jsonSchema of a single field, using korm tags enum, min, max and pattern
+func fieldSchema(f modelField, visited map[reflect.Type]bool) bson.M {
+	s := typeSchema(f.Type, visited)
+	t := f.Type
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if v, ok := f.tagValue("enum"); ok && v != "" {
+		enum := bson.A{}
+		for _, e := range strings.Split(v, ",") {
+			enum = append(enum, tagValueAs(t, strings.TrimSpace(e)))
+		}
+		if f.Type.Kind() == reflect.Pointer {
+			enum = append(enum, nil)
+		}
+		s["enum"] = enum
+	}
+	minKey, maxKey := "minimum", "maximum"
+	switch t.Kind() {
+	case reflect.String:
+		minKey, maxKey = "minLength", "maxLength"
+	case reflect.Slice, reflect.Array:
+		minKey, maxKey = "minItems", "maxItems"
+	}
+	if v, ok := f.tagValue("min"); ok {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			s[minKey] = numberOf(n, minKey != "minimum")
+		}
+	}
+	if v, ok := f.tagValue("max"); ok {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			s[maxKey] = numberOf(n, maxKey != "maximum")
+		}
+	}
+	if v, ok := f.tagValue("pattern"); ok && v != "" {
+		s["pattern"] = v
+	}
+	return s
+}
+
+// typeSchema return the $jsonSchema of a go type, nested structs and slices elements are described too
+func typeSchema(t reflect.Type, visited map[reflect.Type]bool) bson.M {
+	s := bson.M{}
+	if types := bsonTypes(t); len(types) == 1 {
+		s["bsonType"] = types[0]
+	} else if len(types) > 1 {
+		s["bsonType"] = types
+	}
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch {
+	case t.Kind() == reflect.Struct && t != timeType && bsonStructTypes[t] == "" && !customBSON(t):
+		if visited[t] {
+			return s
+		}
+		visited[t] = true
+		defer delete(visited, t)
+		if props, required := structSchema(t, visited); len(props) > 0 {
+			s["properties"] = props
+			if len(required) > 0 {
+				s["required"] = required
+			}
+		}
+	case (t.Kind() == reflect.Slice || t.Kind() == reflect.Array) && t.Elem().Kind() != reflect.Uint8 && !customBSON(t):
+		if items := typeSchema(t.Elem(), visited); len(items) > 0 {
+			s["items"] = items
+		}
+	}
+	return s
+}
+
+// structSchema return the properties and required fields of a struct
+func structSchema(t reflect.Type, visited map[reflect.Type]bool) (bson.M, []string) {
+	props := bson.M{}
+	required := []string{}
+	for _, f := range modelFields(t) {
+		props[f.Column] = fieldSchema(f, visited)
+		if f.hasTag("required") {
+			required = append(required, f.Column)
+		}
+	}
+	return props, required
+}
+
+// modelSchema return the $jsonSchema validator of the model T
+func modelSchema[T comparable]() bson.M {
+	t := reflect.TypeOf(new(T)).Elem()
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schema := bson.M{"bsonType": "object"}
+	if t.Kind() != reflect.Struct {
+		return bson.M{"$jsonSchema": schema}
+	}
+	props, required := structSchema(t, map[reflect.Type]bool{t: true})
+	if len(props) > 0 {
+		schema["properties"] = props
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return bson.M{"$jsonSchema": schema}
+}
+
+// tagValueAs convert an enum value from the korm tag to the kind of t
+func tagValueAs(t reflect.Type, v string) any {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return n
+		}
+	case reflect.Float32, reflect.Float64:
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return n
+		}
+	case reflect.Bool:
+		if b, err := strconv.ParseBool(v); err == nil {
+			return b
+		}
+	}
+	return v
+}
+
+// numberOf return n as int64 when asInt or when n has no decimals, lengths in $jsonSchema must be integers
+func numberOf(n float64, asInt bool) any {
+	if asInt || n == float64(int64(n)) {
+		return int64(n)
+	}
+	return n
+}
@@ -0,0 +1,219 @@
+package kormongo
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// SampleSize is the number of documents sampled per collection by SchemaDiff and GenerateModels
+var SampleSize = 1000
+
+// TableDiff is the drift between a model and its collection returned by SchemaDiff
+type TableDiff struct {
+	Table   string
+	Sampled int
+	// ExtraFields fields found in the collection but not in the model
+	ExtraFields []FieldDiff
+	// MissingFields fields of the model absent from documents, omitempty fields are only reported when never populated
+	MissingFields []FieldDiff
+	// TypeMismatches fields stored with a type different than the model type, one entry by type found
+	TypeMismatches []FieldDiff
+}
+
+// FieldDiff is a field reported by SchemaDiff with the number and the percentage of sampled documents affected
+type FieldDiff struct {
+	Field     string
+	ModelType string
+	DBType    string
+	Documents int
+	Percent   float64
+}
+
+// HasDrift return true if the collection differ from the model
+func (td TableDiff) HasDrift() bool {
+	return len(td.ExtraFields) > 0 || len(td.MissingFields) > 0 || len(td.TypeMismatches) > 0
+}
+
+// SchemaDiff compare models registered with AutoMigrate or Model[T] to a sample of documents of their collections, for the optional dbName given, otherwise the first connected database
+func SchemaDiff(dbName ...string) ([]TableDiff, error) {
+	name := ""
+	if len(dbName) > 0 {
+		name = dbName[0]
+	}
+	db, err := GetMemoryDatabase(name)
+	if err != nil {
+		return nil, err
+	}
+	existing := map[string]bool{}
+	for _, t := range GetAllTables(db.Name) {
+		existing[t] = true
+	}
+	models := map[string]reflect.Type{}
+	for model, table := range mModelTablename {
+		if existing[table] {
+			models[table] = reflect.TypeOf(model)
+		}
+	}
+	tables := make([]string, 0, len(models))
+	for t := range models {
+		tables = append(tables, t)
+	}
+	sort.Strings(tables)
+
+	res := []TableDiff{}
+	for _, table := range tables {
+		td, err := tableDiff(db, table, models[table])
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, td)
+	}
+	return res, nil
+}
+
+func tableDiff(db *DatabaseEntity, table string, model reflect.Type) (TableDiff, error) {
+	td := TableDiff{
+		Table:          table,
+		ExtraFields:    []FieldDiff{},
+		MissingFields:  []FieldDiff{},
+		TypeMismatches: []FieldDiff{},
+	}
+	ctx := context.Background()
+	cur, err := db.MongoConn.Collection(table).Aggregate(ctx, bson.A{
+		bson.M{"$sample": bson.M{"size": SampleSize}},
+	})
+	if err != nil {
+		return td, err
+	}
+	docs := []bson.M{}
+	if err := cur.All(ctx, &docs); err != nil {
+		return td, err
+	}
+	td.Sampled = len(docs)
+	if td.Sampled == 0 {
+		return td, nil
+	}
+
+	fields := map[string]modelField{}
+	for _, f := range modelFields(model) {
+		fields[f.Column] = f
+	}
+	present := map[string]int{}
+	extra := map[string]map[string]int{}
+	mismatches := map[string]map[string]int{}
+	for _, doc := range docs {
+		for k, v := range doc {
+			dbType := valueType(v)
+			f, ok := fields[k]
+			if !ok {
+				if extra[k] == nil {
+					extra[k] = map[string]int{}
+				}
+				extra[k][dbType]++
+				continue
+			}
+			present[k]++
+			if !typeCompatible(f.Type, dbType) {
+				if mismatches[k] == nil {
+					mismatches[k] = map[string]int{}
+				}
+				mismatches[k][dbType]++
+			}
+		}
+	}
+
+	percent := func(n int) float64 {
+		return float64(n) * 100 / float64(td.Sampled)
+	}
+	for k, types := range extra {
+		n := 0
+		dbTypes := make([]string, 0, len(types))
+		for t, c := range types {
+			n += c
+			dbTypes = append(dbTypes, t)
+		}
+		sort.Strings(dbTypes)
+		dbType := dbTypes[0]
+		if len(dbTypes) > 1 {
+			dbType = "mixed"
+		}
+		td.ExtraFields = append(td.ExtraFields, FieldDiff{Field: k, DBType: dbType, Documents: n, Percent: percent(n)})
+	}
+	for _, f := range modelFields(model) {
+		missing := td.Sampled - present[f.Column]
+		if missing == 0 || (f.OmitEmpty && missing != td.Sampled) {
+			continue
+		}
+		td.MissingFields = append(td.MissingFields, FieldDiff{Field: f.Column, ModelType: f.Type.String(), Documents: missing, Percent: percent(missing)})
+	}
+	for k, types := range mismatches {
+		for t, n := range types {
+			td.TypeMismatches = append(td.TypeMismatches, FieldDiff{Field: k, ModelType: fields[k].Type.String(), DBType: t, Documents: n, Percent: percent(n)})
+		}
+	}
+	sort.Slice(td.ExtraFields, func(i, j int) bool { return td.ExtraFields[i].Field < td.ExtraFields[j].Field })
+	sort.Slice(td.TypeMismatches, func(i, j int) bool {
+		if td.TypeMismatches[i].Field == td.TypeMismatches[j].Field {
+			return td.TypeMismatches[i].DBType < td.TypeMismatches[j].DBType
+		}
+		return td.TypeMismatches[i].Field < td.TypeMismatches[j].Field
+	})
+	return td, nil
+}
+
+// valueType return the type name of a decoded value, same names returned by GetAllColumnsTypes
+func valueType(v any) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case time.Time, primitive.DateTime:
+		return "timestampz"
+	case int, int64, int32:
+		return "int"
+	case float32, float64, primitive.Decimal128:
+		return "float"
+	case primitive.ObjectID:
+		return "primitive.ObjectID"
+	case primitive.A, []any:
+		return "array"
+	case primitive.M, primitive.D, map[string]any:
+		return "object"
+	case primitive.Binary:
+		return "binary"
+	default:
+		return reflect.TypeOf(v).String()
+	}
+}
+
+// typeCompatible check if a value stored as dbType can be decoded in a field of type t
+func typeCompatible(t reflect.Type, dbType string) bool {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+		nullable = true
+	}
+	if t.Kind() == reflect.Interface {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Map:
+		nullable = true
+	}
+	if dbType == "null" {
+		return nullable
+	}
+	modelType := columnType(t)
+	if modelType == dbType {
+		return true
+	}
+	return modelType == "float" && dbType == "int"
+}
@@ -3,10 +3,13 @@ package kormongo
 import (
 	"encoding/json"
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/kamalshkeir/kinput"
 	"github.com/kamalshkeir/klog"
@@ -23,7 +26,7 @@ const (
 var dbInUse string
 
 const helpS string = `Commands :  
-[databases, use, tables, columns, createsuperuser, createuser, getall, get, drop, delete, clear/cls, q/quit/exit, help/commands]
+[databases, use, tables, columns, createsuperuser, createuser, getall, get, drop, delete, slow, explain, cache flush, clear/cls, q/quit/exit, help/commands]
   'databases':
 	  list all connected databases
 
@@ -48,13 +51,23 @@ const helpS string = `Commands :
   'drop':
 	  drop a table given table name
 
+  'slow':
+	  list the slow queries stored in korm.SlowQueriesCollection of the database in use, see korm.SlowQueryThreshold
+
+  'explain':
+	  explain a find or count where field equal_to, show the winning plan, index used, docs examined vs returned and execution time
+
+  'cache flush [table] [addr]':
+	  evict the cached queries of a table in the app running its ksbus server at addr, ex: localhost:9313, and in the replicas connected to it, 'cache flush *' flush all the caches
+
   'clear/cls':
 	  clear console
 `
 
-const commandsS string = "Commands :  [databases, use, tables, columns, getall, get, drop, delete, clear/cls, q!/quit/exit]"
+const commandsS string = "Commands :  [databases, use, tables, columns, getall, get, drop, delete, slow, explain, cache flush, clear/cls, q!/quit/exit]"
 
 // InitShell init the shell and return true if used to stop main
+// args: 'mongoshell', 'migrate [dbName]', 'rollback [n] [dbName]', 'migrations [dbName]', 'gen [-out dir] [-pkg name] [-db dbName] [-sample n] [tables...]'
 func InitShell() bool {
 	args := os.Args
 	if len(args) < 2 {
@@ -91,6 +104,10 @@ func InitShell() bool {
 				return true
 			}
 
+			if args := strings.Fields(command); len(args) > 0 && args[0] == "cache" {
+				cacheCommand(args[1:])
+				continue
+			}
 			switch command {
 			case "quit", "exit", "q", "q!":
 				return true
@@ -125,15 +142,161 @@ func InitShell() bool {
 				dropTable()
 			case "delete":
 				deleteRow()
+			case "slow":
+				listSlowQueries()
+			case "explain":
+				explainRow()
 			default:
 				fmt.Printf(Red, "command not handled, use 'help' or 'commands' to list available commands ")
 			}
 		}
+	case "migrate":
+		err := Migrate(args[2:]...)
+		if err != nil {
+			fmt.Printf(Red, err.Error())
+		} else {
+			fmt.Printf(Green, "migrations applied")
+		}
+		return true
+	case "rollback":
+		n := 1
+		dbArgs := args[2:]
+		if len(dbArgs) > 0 {
+			if v, err := strconv.Atoi(dbArgs[0]); err == nil {
+				n = v
+				dbArgs = dbArgs[1:]
+			}
+		}
+		err := Rollback(n, dbArgs...)
+		if err != nil {
+			fmt.Printf(Red, err.Error())
+		} else {
+			fmt.Printf(Green, strconv.Itoa(n)+" migrations rolled back")
+		}
+		return true
+	case "migrations":
+		printMigrationStatus(args[2:]...)
+		return true
+	case "gen":
+		fs := flag.NewFlagSet("gen", flag.ContinueOnError)
+		dir := fs.String("out", "models", "output directory")
+		pkg := fs.String("pkg", "", "package name, default to the output directory name")
+		db := fs.String("db", "", "database name, default to the first connected database")
+		fs.IntVar(&SampleSize, "sample", SampleSize, "number of documents sampled per table")
+		if err := fs.Parse(args[2:]); err != nil {
+			return true
+		}
+		err := GenerateModels(*dir, *pkg, fs.Args(), *db)
+		if err != nil {
+			fmt.Printf(Red, err.Error())
+		} else {
+			fmt.Printf(Green, "models generated in "+*dir)
+		}
+		return true
 	default:
 		return false
 	}
 }
 
+func printMigrationStatus(dbName ...string) {
+	states, err := MigrationStatus(dbName...)
+	if err != nil {
+		fmt.Printf(Red, err.Error())
+		return
+	}
+	if len(states) == 0 {
+		fmt.Printf(Yellow, "no migrations registered")
+		return
+	}
+	for _, st := range states {
+		switch {
+		case st.Applied && !st.Registered:
+			fmt.Printf(Red, "[applied]  "+st.Id+" "+st.AppliedAt.Format(time.RFC3339)+" (not registered)")
+		case st.Applied:
+			fmt.Printf(Green, "[applied]  "+st.Id+" "+st.AppliedAt.Format(time.RFC3339))
+		default:
+			fmt.Printf(Yellow, "[pending]  "+st.Id)
+		}
+	}
+}
+
+func listSlowQueries() {
+	sqs, err := StoredSlowQueries(dbInUse, SlowQueriesMax)
+	if err != nil {
+		fmt.Printf(Red, err.Error())
+		return
+	}
+	if len(sqs) == 0 {
+		fmt.Printf(Yellow, "no slow queries stored")
+		return
+	}
+	for _, sq := range sqs {
+		fmt.Printf(Yellow, sq.String())
+	}
+}
+
+// cacheCommand run 'cache flush [table] [addr]', the shell is another process than the app so the flush is sent to the ksbus server of the app
+func cacheCommand(args []string) {
+	if len(args) == 0 || args[0] != "flush" {
+		fmt.Printf(Red, "usage: cache flush [table] [addr]")
+		return
+	}
+	table, addr := "", ""
+	if len(args) > 1 {
+		table = args[1]
+	}
+	if len(args) > 2 {
+		addr = args[2]
+	}
+	if table == "" {
+		table = kinput.Input(kinput.Blue, "Table Name (* for all) : ")
+	}
+	if table == "" {
+		fmt.Printf(Red, "table is empty")
+		return
+	}
+	if addr == "" {
+		addr = kinput.Input(kinput.Blue, "Bus address of the app, ex: localhost:9313 : ")
+	}
+	if addr == "" {
+		fmt.Printf(Red, "address is empty")
+		return
+	}
+	if err := InvalidateTableAt(addr, dbInUse, table); err != nil {
+		fmt.Printf(Red, err.Error())
+		return
+	}
+	if table == "*" {
+		fmt.Printf(Green, "caches flush sent to "+addr)
+	} else {
+		fmt.Printf(Green, "cache flush of "+table+" sent to "+addr)
+	}
+}
+
+func explainRow() {
+	tableName := kinput.Input(kinput.Blue, "Table Name : ")
+	if tableName == "" {
+		fmt.Printf(Red, "table name invalid")
+		return
+	}
+	b := Table(tableName).Database(dbInUse)
+	if whereField := kinput.Input(kinput.Blue, "Where field (empty for all) : "); whereField != "" {
+		b.Where(whereField, kinput.Input(kinput.Blue, "Equal to : "))
+	}
+	method := kinput.Input(kinput.Blue, "Method find or count (empty for find) : ")
+	sum, err := b.Explain("executionStats", method)
+	if err != nil {
+		fmt.Printf(Red, "error: "+err.Error())
+		return
+	}
+	color := Green
+	if sum.CollectionScan {
+		color = Yellow
+	}
+	fmt.Printf(color, "plan: "+sum.String())
+	fmt.Printf(color, fmt.Sprintf("docs examined: %d, keys examined: %d, returned: %d, execution time: %dms", sum.DocsExamined, sum.KeysExamined, sum.DocsReturned, sum.ExecutionTimeMs))
+}
+
 func getAll() {
 	tableName, err := kinput.String(kinput.Blue, "Enter a table name: ")
 	if err == nil {
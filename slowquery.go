@@ -0,0 +1,281 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+var (
+	// SlowQueryThreshold operations taking longer are recorded in SlowQueries, 0 disable it, can be overridden per builder using SlowThreshold
+	SlowQueryThreshold time.Duration = 0
+	// SlowQueriesMax is the number of slow queries kept by SlowQueries
+	SlowQueriesMax = 100
+	// SlowExplainEvery is the minimum interval between two explains of the same filter shape, the slow queries in between reuse the last summary
+	SlowExplainEvery = time.Minute
+	// SlowExplainMax is the maximum number of explains run at the same time, the slow queries exceeding it are recorded without summary
+	SlowExplainMax = 2
+	// SlowQueriesCollection is a capped collection, created in the database of each slow query, where they are also stored so other processes like the shell 'slow' command can list them, "" disable it
+	SlowQueriesCollection = ""
+	// SlowQueriesCollectionSize is the size in bytes of SlowQueriesCollection when kormongo create it
+	SlowQueriesCollectionSize int64    = 1 << 20
+	slowQueries                        = &slowRing{}
+	slowExplains                       = &explainLimiter{last: map[string]slowExplain{}}
+	slowCollections           sync.Map // databases where SlowQueriesCollection exist
+	onSlowQueryMu             sync.RWMutex
+	onSlowQuery               func(SlowQuery)
+)
+
+// SlowQuery is an operation that exceeded the slow query threshold
+type SlowQuery struct {
+	Time     time.Time
+	Op       string
+	Database string
+	Table    string
+	// Filter is the shape of the filter, values replaced by '?'
+	Filter   string
+	Duration time.Duration
+	// Explain is the queryPlanner summary of the filter, ex: 'COLLSCAN' or 'FETCH > IXSCAN email_1'
+	Explain string
+}
+
+func (sq SlowQuery) String() string {
+	return fmt.Sprintf("%s %s %s.%s %s %v [%s]", sq.Time.Format(time.RFC3339), sq.Op, sq.Database, sq.Table, sq.Filter, sq.Duration, sq.Explain)
+}
+
+// SlowQueries return the last SlowQueriesMax slow queries of the calling process, oldest first, use SlowQueriesCollection to share them with other processes
+func SlowQueries() []SlowQuery {
+	return slowQueries.all()
+}
+
+// StoredSlowQueries return the last n slow queries stored in SlowQueriesCollection of the database by all the processes, oldest first
+func StoredSlowQueries(dbName string, n int) ([]SlowQuery, error) {
+	if SlowQueriesCollection == "" {
+		return nil, errors.New("korm.SlowQueriesCollection not set")
+	}
+	db, err := GetMemoryDatabase(dbName)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	// natural order is the insertion order in a capped collection
+	opts := options.Find().SetSort(bson.D{{Key: "$natural", Value: -1}})
+	if n > 0 {
+		opts.SetLimit(int64(n))
+	}
+	cursor, err := db.MongoConn.Collection(SlowQueriesCollection).Find(ctx, bson.M{}, opts)
+	if err != nil {
+		return nil, err
+	}
+	res := []SlowQuery{}
+	if err := cursor.All(ctx, &res); err != nil {
+		return nil, err
+	}
+	for i, j := 0, len(res)-1; i < j; i, j = i+1, j-1 {
+		res[i], res[j] = res[j], res[i]
+	}
+	return res, nil
+}
+
+// storeSlowQuery insert sq in SlowQueriesCollection, creating it capped the first time
+func storeSlowQuery(db *mongo.Database, sq SlowQuery) {
+	coll := SlowQueriesCollection
+	if coll == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, ok := slowCollections.Load(db.Name()); !ok {
+		err := db.CreateCollection(ctx, coll, options.CreateCollection().SetCapped(true).SetSizeInBytes(SlowQueriesCollectionSize))
+		var se mongo.ServerError
+		if err != nil && !(errors.As(err, &se) && se.HasErrorCode(namespaceExists)) {
+			logger.Error("kormongo slow query not stored", "database", db.Name(), "collection", coll, "err", err)
+			return
+		}
+		slowCollections.Store(db.Name(), true)
+	}
+	if _, err := db.Collection(coll).InsertOne(ctx, sq); err != nil {
+		logger.Error("kormongo slow query not stored", "database", db.Name(), "collection", coll, "err", err)
+	}
+}
+
+// OnSlowQuery set a callback executed for each slow query, after the explain summary is ready
+func OnSlowQuery(fn func(sq SlowQuery)) {
+	onSlowQueryMu.Lock()
+	onSlowQuery = fn
+	onSlowQueryMu.Unlock()
+}
+
+type slowRing struct {
+	mu    sync.Mutex
+	items []SlowQuery
+	next  int
+}
+
+func (r *slowRing) add(sq SlowQuery) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	max := SlowQueriesMax
+	if max <= 0 {
+		return
+	}
+	if len(r.items) < max {
+		r.items = append(r.items, sq)
+		r.next = len(r.items) % max
+		return
+	}
+	if len(r.items) > max {
+		r.items = r.items[len(r.items)-max:]
+		r.next = 0
+	}
+	r.items[r.next] = sq
+	r.next = (r.next + 1) % max
+}
+
+func (r *slowRing) all() []SlowQuery {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	res := make([]SlowQuery, 0, len(r.items))
+	if len(r.items) == 0 {
+		return res
+	}
+	start := r.next % len(r.items)
+	res = append(res, r.items[start:]...)
+	res = append(res, r.items[:start]...)
+	return res
+}
+
+type slowExplain struct {
+	at      time.Time
+	summary string
+}
+
+// explainLimiter limit the explains of the slow queries, a query slow because of a missing index is often slow for every call
+type explainLimiter struct {
+	mu      sync.Mutex
+	running int
+	last    map[string]slowExplain
+}
+
+// acquire return true if the explain of the filter shape key can run, release must be called once done
+// otherwise it return the summary to use: the one of the last explain of key, or why it was skipped
+func (l *explainLimiter) acquire(key string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.last[key]; ok && time.Since(e.at) < SlowExplainEvery {
+		return e.summary, false
+	}
+	if l.running >= SlowExplainMax {
+		return "explain skipped: too many explains running", false
+	}
+	if len(l.last) >= 1000 {
+		for k, e := range l.last {
+			if time.Since(e.at) >= SlowExplainEvery {
+				delete(l.last, k)
+			}
+		}
+	}
+	l.running++
+	l.last[key] = slowExplain{at: time.Now(), summary: "explain in progress"}
+	return "", true
+}
+
+func (l *explainLimiter) release(key, summary string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.running--
+	if e, ok := l.last[key]; ok {
+		e.summary = summary
+		l.last[key] = e
+	}
+}
+
+// checkSlow record the operation if it took longer than the builder or global threshold, the explain run in background, limited by SlowExplainEvery and SlowExplainMax
+func (q *queryLog) checkSlow(d time.Duration) {
+	threshold := q.slow
+	if threshold == 0 {
+		threshold = SlowQueryThreshold
+	}
+	if threshold <= 0 || d < threshold || q.cache == cacheHit {
+		return
+	}
+	sq := SlowQuery{
+		Time:     q.start,
+		Op:       q.op,
+		Database: q.database,
+		Table:    q.table,
+		Filter:   filterShape(q.filter),
+		Duration: d,
+	}
+	go func() {
+		db, dbErr := GetMemoryDatabase(q.database)
+		if dbErr == nil && q.op != "insert" && q.op != "drop" {
+			key := sq.Database + "." + sq.Table + " " + sq.Op + " " + sq.Filter + " " + q.sort
+			summary, run := slowExplains.acquire(key)
+			if run {
+				ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				method := "find"
+				if q.op == "count" {
+					method = "count"
+				}
+				sum, err := explainMethod(ctx, db.MongoConn, method, q.table, q.filter, q.projection, q.sort, 0, 0, "queryPlanner")
+				cancel()
+				if err == nil {
+					summary = sum.String()
+				} else {
+					summary = "explain failed: " + err.Error()
+				}
+				slowExplains.release(key, summary)
+			}
+			sq.Explain = summary
+		}
+		slowQueries.add(sq)
+		if dbErr == nil {
+			storeSlowQuery(db.MongoConn, sq)
+		}
+		logger.Warn("kormongo slow query", "op", sq.Op, "database", sq.Database, "table", sq.Table, "filter", sq.Filter, "duration", sq.Duration, "explain", sq.Explain)
+		onSlowQueryMu.RLock()
+		fn := onSlowQuery
+		onSlowQueryMu.RUnlock()
+		if fn != nil {
+			fn(sq)
+		}
+	}()
+}
+
+// filterShape return the filter with values replaced by '?', keys sorted, ex: {age:{$gt:?},email:?}
+func filterShape(filter map[string]any) string {
+	if len(filter) == 0 {
+		return "{}"
+	}
+	keys := make([]string, 0, len(filter))
+	for k := range filter {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, k+":"+valueShape(filter[k]))
+	}
+	return "{" + strings.Join(parts, ",") + "}"
+}
+
+func valueShape(v any) string {
+	switch vv := v.(type) {
+	case map[string]any:
+		return filterShape(vv)
+	case nil:
+		return "null"
+	default:
+		return "?"
+	}
+}
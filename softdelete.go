@@ -0,0 +1,116 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+const (
+	withoutTrashed = iota
+	withTrashed
+	onlyTrashed
+)
+
+// softDeleteField return the field tagged korm:"softdelete", nil if the model has no soft delete
+func softDeleteField(t reflect.Type) *modelField {
+	fields := modelFields(t)
+	for i := range fields {
+		if fields[i].hasTag("softdelete") {
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// softDeleteColumn return the soft delete column of T, "" if T has no soft delete
+func softDeleteColumn[T comparable]() string {
+	if f := softDeleteField(reflect.TypeOf(new(T)).Elem()); f != nil {
+		return f.Column
+	}
+	return ""
+}
+
+// scope add the soft delete condition to the filter wf
+func (b *Builder[T]) scope(wf map[string]any) map[string]any {
+	col := softDeleteColumn[T]()
+	if col == "" || b.trashed == withTrashed {
+		return wf
+	}
+	if wf == nil {
+		wf = map[string]any{}
+	}
+	if _, ok := wf[col]; ok {
+		return wf
+	}
+	if b.trashed == onlyTrashed {
+		wf[col] = map[string]any{"$ne": nil}
+	} else {
+		wf[col] = nil
+	}
+	return wf
+}
+
+// WithTrashed include soft deleted documents in All, One and Count
+func (b *Builder[T]) WithTrashed() *Builder[T] {
+	b.trashed = withTrashed
+	return b
+}
+
+// OnlyTrashed return only soft deleted documents in All, One and Count
+func (b *Builder[T]) OnlyTrashed() *Builder[T] {
+	b.trashed = onlyTrashed
+	return b
+}
+
+// ForceDelete delete the document even if the model use soft delete
+func (b *Builder[T]) ForceDelete() (int, error) {
+	b.force = true
+	return b.Delete()
+}
+
+// Restore restore a soft deleted document matching Where
+func (b *Builder[T]) Restore() (n int, err error) {
+	if b.tableName == "" {
+		tName := getTableName[T]()
+		if tName == "" {
+			return 0, ErrTableNotLinked
+		}
+		b.tableName = tName
+	}
+	col := softDeleteColumn[T]()
+	if col == "" {
+		return 0, errors.New("model " + b.tableName + " has no korm:\"softdelete\" field")
+	}
+	if b.database == "" {
+		b.database = databases[0].Name
+	}
+	ql := newQueryLog(b.debug, b.slow, "restore", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return 0, err
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	b.trashed = onlyTrashed
+	wf := b.scope(b.whereFields())
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "restore", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		ql.filter = op.Filter
+		id, err := updateOne(op.Ctx, db.MongoConn, b.tableName, op.Filter, map[string]any{"$set": map[string]any{col: nil}})
+		if err == nil && id == nil {
+			err = mongo.ErrNoDocuments
+		}
+		err = queryError("restore", db.Name, b.tableName, op.Filter, err)
+		// the restored document leave the trashed results and join the ones filtering on col
+		invalidateWrite("update", db.Name, b.tableName, id, err, []string{col}, false, flagTag(db.Name, b.tableName, "trashed"))
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
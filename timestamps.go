@@ -0,0 +1,86 @@
+package kormongo
+
+import (
+	"reflect"
+	"time"
+)
+
+// now return the current time in UTC truncated to milliseconds, the precision stored by mongo
+func now() time.Time {
+	return time.Now().UTC().Truncate(time.Millisecond)
+}
+
+// timestampFields return the fields tagged korm:"created" and korm:"updated", or named CreatedAt and UpdatedAt
+func timestampFields(t reflect.Type) (created, updated *modelField) {
+	fields := modelFields(t)
+	for i := range fields {
+		f := &fields[i]
+		ft := f.Type
+		if ft.Kind() == reflect.Pointer {
+			ft = ft.Elem()
+		}
+		if ft != timeType {
+			continue
+		}
+		switch {
+		case f.hasTag("created"):
+			created = f
+		case f.hasTag("updated"):
+			updated = f
+		case f.Name == "CreatedAt" && created == nil:
+			created = f
+		case f.Name == "UpdatedAt" && updated == nil:
+			updated = f
+		}
+	}
+	return created, updated
+}
+
+// setTimestamps set the updated field of the model pointed by model, and the created field if zero when created is true
+func setTimestamps(model any, created bool) {
+	v := reflect.ValueOf(model)
+	if v.Kind() != reflect.Pointer || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+		return
+	}
+	v = v.Elem()
+	createdField, updated := timestampFields(v.Type())
+	if !created {
+		createdField = nil
+	}
+	if createdField == nil && updated == nil {
+		return
+	}
+	t := now()
+	if createdField != nil {
+		if fv, err := v.FieldByIndexErr(createdField.Index); err == nil && fv.IsZero() {
+			setTime(fv, t)
+		}
+	}
+	if updated != nil {
+		if fv, err := v.FieldByIndexErr(updated.Index); err == nil {
+			setTime(fv, t)
+		}
+	}
+}
+
+func setTime(fv reflect.Value, t time.Time) {
+	if !fv.CanSet() {
+		return
+	}
+	if fv.Kind() == reflect.Pointer {
+		fv.Set(reflect.ValueOf(&t))
+		return
+	}
+	fv.Set(reflect.ValueOf(t))
+}
+
+// setUpdatedTimestamp add the updated column of T to the update if not already set by the caller
+func setUpdatedTimestamp[T comparable](update map[string]any) {
+	_, updated := timestampFields(reflect.TypeOf(new(T)).Elem())
+	if updated == nil {
+		return
+	}
+	if _, ok := update[updated.Column]; !ok {
+		update[updated.Column] = now()
+	}
+}
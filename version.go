@@ -0,0 +1,127 @@
+package kormongo
+
+import (
+	"context"
+	"errors"
+	"reflect"
+)
+
+// versionField return the integer field tagged korm:"version", nil if the model is not versioned
+func versionField(t reflect.Type) *modelField {
+	fields := modelFields(t)
+	for i := range fields {
+		if !fields[i].hasTag("version") {
+			continue
+		}
+		switch fields[i].Type.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+			reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return &fields[i]
+		}
+	}
+	return nil
+}
+
+// versionColumn return the version column of T, "" if T is not versioned
+func versionColumn[T comparable]() string {
+	if f := versionField(reflect.TypeOf(new(T)).Elem()); f != nil {
+		return f.Column
+	}
+	return ""
+}
+
+// Replace replace the document matching Where, or the _id of model if no Where, by model
+// versioned models are only replaced if the stored version equal the version of model, ErrStaleObject is returned otherwise, on success the version of model is incremented
+func (b *Builder[T]) Replace(model *T) (n int, err error) {
+	if b.tableName == "" {
+		tName := getTableName[T]()
+		if tName == "" {
+			return 0, ErrTableNotLinked
+		}
+		b.tableName = tName
+	}
+	if b.database == "" {
+		b.database = databases[0].Name
+	}
+	ql := newQueryLog(b.debug, b.slow, "replace", b.database, b.tableName)
+	defer func() { ql.done(n, err) }()
+	db, err := GetMemoryDatabase(b.database)
+	if err != nil {
+		return 0, err
+	}
+	if b.ctx == nil {
+		b.ctx = context.Background()
+	}
+	if model == nil {
+		return 0, errors.New("replace need a non nil model")
+	}
+	v := reflect.ValueOf(model).Elem()
+	wf := b.whereFields()
+	if len(wf) == 0 {
+		for _, f := range modelFields(v.Type()) {
+			if f.Column != "_id" {
+				continue
+			}
+			if fv, err := v.FieldByIndexErr(f.Index); err == nil && !fv.IsZero() {
+				wf["_id"] = fv.Interface()
+			}
+		}
+	}
+	if len(wf) == 0 {
+		return 0, errors.New("replace need a Where or a model with an _id")
+	}
+	ql.filter = wf
+	hctx := withHookContext(b.ctx, &HookContext{Database: db.Name, Table: b.tableName, Filter: wf})
+	if h, ok := any(model).(BeforeUpdater); ok {
+		if err := h.BeforeUpdate(hctx); err != nil {
+			return 0, err
+		}
+	}
+	setTimestamps(model, false)
+
+	var versionValue reflect.Value
+	var oldVersion reflect.Value
+	if vf := versionField(v.Type()); vf != nil {
+		if fv, err := v.FieldByIndexErr(vf.Index); err == nil {
+			versionValue = fv
+			oldVersion = reflect.New(fv.Type()).Elem()
+			oldVersion.Set(fv)
+			wf[vf.Column] = fv.Interface()
+			if fv.CanInt() {
+				fv.SetInt(fv.Int() + 1)
+			} else {
+				fv.SetUint(fv.Uint() + 1)
+			}
+		}
+	}
+	err = runOp(&OpInfo{Ctx: b.ctx, Kind: "replace", Database: db.Name, Table: b.tableName, Filter: wf}, func(op *OpInfo) error {
+		ql.filter = op.Filter
+		fields := []string{}
+		for _, f := range modelFields(v.Type()) {
+			fields = append(fields, f.Column)
+		}
+		id, err := replaceOne(op.Ctx, db.MongoConn, b.tableName, op.Filter, model)
+		if err == nil && id == nil {
+			if versionValue.IsValid() {
+				err = ErrStaleObject
+			} else {
+				err = ErrNotFound
+			}
+		}
+		err = queryError("replace", db.Name, b.tableName, op.Filter, err)
+		invalidateWrite("update", db.Name, b.tableName, id, err, fields, false)
+		return err
+	})
+	if err != nil {
+		if versionValue.IsValid() {
+			versionValue.Set(oldVersion)
+		}
+		return 0, err
+	}
+	if h, ok := any(model).(AfterUpdater); ok {
+		if err := h.AfterUpdate(hctx); err != nil {
+			return 1, err
+		}
+	}
+	return 1, nil
+}